@@ -0,0 +1,87 @@
+package birdwatcher
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// APIPathPrefix is the path under which all status API endpoints are served
+const APIPathPrefix = "/api/"
+
+// maximum size of the body of a request pushing the state of a service
+const maxPushSize = 64 * 1024
+
+// NewAPIHandler returns an http.Handler serving the JSON status API for given
+// HealthCheck
+func NewAPIHandler(h *HealthCheck) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/v1/services", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, h.ServiceStatuses())
+	})
+
+	mux.HandleFunc("POST /api/v1/services/{name}/state", func(w http.ResponseWriter, r *http.Request) {
+		var req PushRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPushSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+
+			return
+		}
+
+		secret, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		err := h.Push(r.PathValue("name"), secret, req)
+
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, errUnknownService), errors.Is(err, errNotPassive):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, errUnauthorized):
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		case errors.Is(err, errInvalidState):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+	})
+
+	mux.HandleFunc("GET /api/v1/prefixes", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, h.AnnouncedPrefixes())
+	})
+
+	mux.HandleFunc("GET /api/v1/peers", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, h.Peers())
+	})
+
+	mux.HandleFunc("GET "+PeeringStatePath, func(w http.ResponseWriter, r *http.Request) {
+		if h.peering == nil {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		if !h.peering.authorized(r) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+			return
+		}
+
+		writeJSON(w, h.NodeState())
+	})
+
+	return mux
+}
+
+// writeJSON writes given value as JSON response
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithError(err).Warning("could not write API response")
+	}
+}
@@ -0,0 +1,81 @@
+package birdwatcher
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIServices(t *testing.T) {
+	t.Parallel()
+
+	hc := NewHealthCheck(Config{})
+	hc.services = []*ServiceCheck{
+		{
+			name:         "foo",
+			FunctionName: "match_route",
+			prefixes: []Prefix{
+				{IPNet: net.IPNet{IP: net.IP{1, 2, 3, 0}, Mask: net.IPMask{255, 255, 255, 0}}},
+			},
+		},
+		{name: "bar", FunctionName: "match_route", state: ServiceStateUp, successes: 3, transitions: 1},
+	}
+
+	rec := httptest.NewRecorder()
+	NewAPIHandler(hc).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/services", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var statuses []ServiceStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+
+	// services should be sorted by name
+	if assert.Len(t, statuses, 2) {
+		assert.Equal(t, "bar", statuses[0].Name)
+		assert.Equal(t, ServiceStateUp, statuses[0].State)
+		assert.Equal(t, uint64(3), statuses[0].Successes)
+		assert.Equal(t, uint64(1), statuses[0].Transitions)
+
+		assert.Equal(t, "foo", statuses[1].Name)
+		assert.Equal(t, ServiceStateDown, statuses[1].State)
+		assert.Equal(t, []string{"1.2.3.0/24"}, statuses[1].Prefixes)
+	}
+}
+
+func TestAPIPrefixes(t *testing.T) {
+	t.Parallel()
+
+	hc := NewHealthCheck(Config{})
+
+	_, prefix, _ := parsePrefix("1.2.3.0/24")
+	hc.addPrefix(&ServiceCheck{name: "svc1", FunctionName: "foo"}, prefix)
+	hc.removePrefix(&ServiceCheck{name: "svc2", FunctionName: "bar"}, prefix)
+
+	rec := httptest.NewRecorder()
+	NewAPIHandler(hc).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/prefixes", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var prefixes map[string][]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &prefixes))
+
+	assert.Equal(t, map[string][]string{
+		"foo": {"1.2.3.0/24"},
+		"bar": {},
+	}, prefixes)
+}
+
+func TestAPIMethodNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	NewAPIHandler(NewHealthCheck(Config{})).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/services", nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	_ "embed"
 	"errors"
-	"net"
 	"os"
 	"text/template"
 )
@@ -20,8 +19,16 @@ var tplFuncs = template.FuncMap{
 var errConfigIdentical = errors.New("configuration file is identical")
 
 func updateBirdConfig(config Config, prefixes PrefixCollection) error {
+	return updateConfigFile(config.ConfigFile, func(filename string) error {
+		return writeBirdConfig(filename, prefixes, config.CompatBird213)
+	})
+}
+
+// updateConfigFile writes a new version of given config file using the write
+// function and only replaces the original file if the content changed
+func updateConfigFile(configFile string, write func(filename string) error) error {
 	// write config to temp file
-	tmpFilename := config.ConfigFile + ".tmp"
+	tmpFilename := configFile + ".tmp"
 	// make sure we don't keep tmp file around when something goes wrong
 	defer func(x string) {
 		if _, err := os.Stat(x); !os.IsNotExist(err) {
@@ -30,17 +37,17 @@ func updateBirdConfig(config Config, prefixes PrefixCollection) error {
 		}
 	}(tmpFilename)
 
-	if err := writeBirdConfig(tmpFilename, prefixes, config.CompatBird213); err != nil {
+	if err := write(tmpFilename); err != nil {
 		return err
 	}
 
 	// compare new file with original config file
-	if compareFiles(tmpFilename, config.ConfigFile) {
+	if compareFiles(tmpFilename, configFile) {
 		return errConfigIdentical
 	}
 
 	// move tmp file to right place
-	return os.Rename(tmpFilename, config.ConfigFile)
+	return os.Rename(tmpFilename, configFile)
 }
 
 func writeBirdConfig(filename string, prefixes PrefixCollection, compatBird213 bool) error {
@@ -76,7 +83,7 @@ func writeBirdConfig(filename string, prefixes PrefixCollection, compatBird213 b
 // prefixPad is a helper function for the template
 // basically returns CIDR notations per IPNet, each suffixed with a , except for
 // the last entry
-func prefixPad(x []net.IPNet) []string {
+func prefixPad(x []Prefix) []string {
 	pp := make([]string, len(x))
 	for i, p := range x {
 		pp[i] = p.String()
@@ -1,8 +1,8 @@
 package birdwatcher
 
 import (
-	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -50,8 +50,8 @@ func TestWriteBirdConfig(t *testing.T) {
 		prefixes["match_route"] = NewPrefixSet("match_route")
 
 		for _, pref := range []string{"1.2.3.4/32", "2.3.4.5/26", "3.4.5.6/24", "4.5.6.7/21"} {
-			_, prf, _ := net.ParseCIDR(pref)
-			prefixes["match_route"].Add(*prf)
+			_, prf, _ := parsePrefix(pref)
+			prefixes["match_route"].Add(prf)
 		}
 
 		// write bird config to it
@@ -80,8 +80,8 @@ func TestWriteBirdConfig(t *testing.T) {
 
 		prefixes["other_function"] = NewPrefixSet("other_function")
 		for _, pref := range []string{"5.6.7.8/32", "6.7.8.9/26", "7.8.9.10/24"} {
-			_, prf, _ := net.ParseCIDR(pref)
-			prefixes["other_function"].Add(*prf)
+			_, prf, _ := parsePrefix(pref)
+			prefixes["other_function"].Add(prf)
 		}
 
 		// write bird config to it
@@ -97,16 +97,44 @@ func TestWriteBirdConfig(t *testing.T) {
 
 		assert.Equal(t, string(fixture), string(data))
 	})
+
+	t.Run("prefix ranges", func(t *testing.T) {
+		t.Parallel()
+
+		filename := filepath.Join(t.TempDir(), "bird_test")
+
+		prefixes := make(PrefixCollection)
+		prefixes["match_route"] = NewPrefixSet("match_route")
+
+		for _, pref := range []string{"10.0.0.0/16{24,32}", "192.168.0.0/24+", "172.16.0.0/12-", "2001:db8::/32{48,64}"} {
+			_, prf, err := parsePrefix(pref)
+			require.NoError(t, err)
+			prefixes["match_route"].Add(prf)
+		}
+
+		// write bird config, which should keep the prefix patterns
+		err := writeBirdConfig(filename, prefixes, false)
+		require.NoError(t, err)
+
+		// read data from temp file and compare it to file fixture
+		data, err := os.ReadFile(filename)
+		require.NoError(t, err)
+
+		fixture, err := os.ReadFile("testdata/bird/config_ranges")
+		require.NoError(t, err)
+
+		assert.Equal(t, string(fixture), string(data))
+	})
 }
 
 func TestPrefixPad(t *testing.T) {
 	t.Parallel()
 
-	prefixes := make([]net.IPNet, 4)
+	prefixes := make([]Prefix, 4)
 
 	for i, pref := range []string{"1.2.3.0/24", "2.3.4.0/24", "3.4.5.0/24", "3.4.5.0/26"} {
-		_, prf, _ := net.ParseCIDR(pref)
-		prefixes[i] = *prf
+		_, prf, _ := parsePrefix(pref)
+		prefixes[i] = prf
 	}
 
 	padded := prefixPad(prefixes)
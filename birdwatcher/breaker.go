@@ -0,0 +1,374 @@
+package birdwatcher
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// WithdrawalStoreDirectory keeps track of withdrawals using marker files
+	// in a directory, which can be shared between nodes using a shared
+	// filesystem
+	WithdrawalStoreDirectory = "directory"
+	// WithdrawalStorePeers uses the service states fetched from peers
+	WithdrawalStorePeers = "peers"
+
+	// time after which markers of nodes that stopped refreshing them expire
+	defaultMarkerTTL = 10 * time.Minute
+)
+
+var (
+	breakerTrippedMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "circuitbreaker",
+		Name:      "tripped",
+		Help:      "Whether the circuit breaker prevents withdrawing the prefixes of a service",
+	}, []string{"service"})
+
+	breakerWithdrawnMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "circuitbreaker",
+		Name:      "withdrawn_peers",
+		Help:      "Number of peers that withdrew the prefixes of a service",
+	}, []string{"service"})
+
+	breakerErrorMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "circuitbreaker",
+		Name:      "errors_total",
+		Help:      "Number of times the withdrawal store could not be consulted or updated",
+	})
+)
+
+// CircuitBreakerConfig holds configuration of the circuit breaker preventing
+// all nodes of the fleet from withdrawing the same service
+type CircuitBreakerConfig struct {
+	Enabled      bool
+	Store        string
+	Directory    string
+	MaxWithdrawn int
+	Node         string
+	MarkerTTL    time.Duration
+}
+
+// WithdrawalStore keeps track of which nodes withdrew the prefixes of which
+// services
+type WithdrawalStore interface {
+	// Withdrawn returns the nodes that withdrew the prefixes of given service
+	Withdrawn(service string) ([]string, error)
+	// MarkWithdrawn records given node withdrew the prefixes of given service
+	MarkWithdrawn(service, node string) error
+	// ClearWithdrawn records given node announces the prefixes of given
+	// service again
+	ClearWithdrawn(service, node string) error
+}
+
+// validateCircuitBreaker validates the circuit breaker configuration and sets
+// its defaults
+func validateCircuitBreaker(c *CircuitBreakerConfig, peering PeeringConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Store == "" {
+		c.Store = WithdrawalStoreDirectory
+	}
+
+	switch c.Store {
+	case WithdrawalStoreDirectory:
+		if c.Directory == "" {
+			return errors.New("circuitbreaker has no directory set")
+		}
+
+		if c.MarkerTTL < 0 {
+			return errors.New("circuitbreaker markerttl should not be negative")
+		}
+
+		if c.MarkerTTL == 0 {
+			c.MarkerTTL = defaultMarkerTTL
+		}
+	case WithdrawalStorePeers:
+		if !peering.Enabled {
+			return errors.New("circuitbreaker store peers requires peering to be enabled")
+		}
+	default:
+		return fmt.Errorf("circuitbreaker has unknown store %s", c.Store)
+	}
+
+	if c.MaxWithdrawn <= 0 {
+		return errors.New("circuitbreaker maxwithdrawn should be larger than 0")
+	}
+
+	if c.Node == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("circuitbreaker has no node set and hostname is unknown: %w", err)
+		}
+
+		c.Node = hostname
+	}
+
+	return nil
+}
+
+// circuitBreaker decides whether this node may withdraw the prefixes of a
+// service, based on how many peers already did
+type circuitBreaker struct {
+	store        WithdrawalStore
+	node         string
+	maxWithdrawn int
+	// interval in which markers of this node are refreshed, if they expire
+	refreshInterval time.Duration
+
+	mu sync.Mutex
+	// services this node marked withdrawn, by the time they were marked
+	withdrawn map[string]time.Time
+}
+
+// newCircuitBreaker returns a circuit breaker for given configuration, or nil
+// if disabled
+func newCircuitBreaker(c CircuitBreakerConfig, peering *Peering) *circuitBreaker {
+	if !c.Enabled {
+		return nil
+	}
+
+	var (
+		store           WithdrawalStore
+		refreshInterval time.Duration
+	)
+
+	switch c.Store {
+	case WithdrawalStoreDirectory:
+		store = NewDirectoryStore(c.Directory, c.MarkerTTL)
+		// refresh well before markers expire
+		refreshInterval = c.MarkerTTL / 3
+	case WithdrawalStorePeers:
+		if peering == nil {
+			return nil
+		}
+
+		store = peering
+	default:
+		return nil
+	}
+
+	return &circuitBreaker{
+		store:           store,
+		node:            c.Node,
+		maxWithdrawn:    c.MaxWithdrawn,
+		refreshInterval: refreshInterval,
+		withdrawn:       map[string]time.Time{},
+	}
+}
+
+// claim returns whether this node may withdraw the prefixes of given service,
+// and if so, records this node withdrew them. Since peers may claim the same
+// service at the same moment, the peers are counted again after recording
+// the withdrawal, which is undone when too many peers withdrew. Peers racing
+// each other may all back off this way, but never exceed maxwithdrawn.
+func (b *circuitBreaker) claim(service string) bool {
+	if b == nil {
+		return true
+	}
+
+	if !b.allows(service) {
+		return false
+	}
+
+	b.markWithdrawn(service)
+
+	if !b.allows(service) {
+		b.forget(service)
+
+		return false
+	}
+
+	return true
+}
+
+// allows returns whether this node may withdraw the prefixes of given
+// service. When the store can't be consulted, withdrawing is allowed, like
+// without circuit breaker.
+func (b *circuitBreaker) allows(service string) bool {
+	if b == nil {
+		return true
+	}
+
+	nodes, err := b.store.Withdrawn(service)
+	if err != nil {
+		breakerErrorMetric.Inc()
+		log.WithError(err).WithField("service", service).Error("could not consult withdrawal store, allowing withdrawal")
+
+		return true
+	}
+
+	// only count peers, this node may have withdrawn before
+	nodes = slices.DeleteFunc(nodes, func(node string) bool {
+		return node == b.node
+	})
+
+	breakerWithdrawnMetric.WithLabelValues(service).Set(float64(len(nodes)))
+
+	if len(nodes) >= b.maxWithdrawn {
+		breakerTrippedMetric.WithLabelValues(service).Set(1)
+
+		return false
+	}
+
+	breakerTrippedMetric.WithLabelValues(service).Set(0)
+
+	return true
+}
+
+// markWithdrawn records this node withdrew the prefixes of given service
+func (b *circuitBreaker) markWithdrawn(service string) {
+	if b == nil {
+		return
+	}
+
+	if err := b.store.MarkWithdrawn(service, b.node); err != nil {
+		breakerErrorMetric.Inc()
+		log.WithError(err).WithField("service", service).Error("could not record withdrawal")
+
+		return
+	}
+
+	b.mu.Lock()
+	b.withdrawn[service] = time.Now()
+	b.mu.Unlock()
+}
+
+// refresh records the withdrawals of this node again before their markers
+// expire
+func (b *circuitBreaker) refresh() {
+	if b == nil || b.refreshInterval <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+
+	var services []string
+
+	for service, marked := range b.withdrawn {
+		if time.Since(marked) >= b.refreshInterval {
+			services = append(services, service)
+		}
+	}
+
+	b.mu.Unlock()
+
+	for _, service := range services {
+		b.markWithdrawn(service)
+	}
+}
+
+// clearWithdrawn records this node announces the prefixes of given service
+func (b *circuitBreaker) clearWithdrawn(service string) {
+	if b == nil {
+		return
+	}
+
+	breakerTrippedMetric.WithLabelValues(service).Set(0)
+	b.forget(service)
+}
+
+// forget removes the withdrawal of given service by this node
+func (b *circuitBreaker) forget(service string) {
+	b.mu.Lock()
+	delete(b.withdrawn, service)
+	b.mu.Unlock()
+
+	if err := b.store.ClearWithdrawn(service, b.node); err != nil {
+		breakerErrorMetric.Inc()
+		log.WithError(err).WithField("service", service).Error("could not record announcement")
+	}
+}
+
+// DirectoryStore is a WithdrawalStore keeping a marker file per service and
+// node in a directory. Markers that were not refreshed within their TTL are
+// ignored, so nodes that went away don't count as withdrawn forever.
+type DirectoryStore struct {
+	dir string
+	ttl time.Duration
+}
+
+// NewDirectoryStore returns a DirectoryStore using given directory, ignoring
+// markers older than given TTL. Without TTL, markers never expire.
+func NewDirectoryStore(dir string, ttl time.Duration) *DirectoryStore {
+	return &DirectoryStore{dir: dir, ttl: ttl}
+}
+
+// serviceDir returns the directory holding the markers of given service
+func (d *DirectoryStore) serviceDir(service string) string {
+	return filepath.Join(d.dir, url.PathEscape(service))
+}
+
+// Withdrawn returns the nodes that withdrew the prefixes of given service
+func (d *DirectoryStore) Withdrawn(service string) ([]string, error) {
+	entries, err := os.ReadDir(d.serviceDir(service))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	nodes := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		node, err := url.PathUnescape(entry.Name())
+		if err != nil || entry.IsDir() {
+			// not one of our markers
+			continue
+		}
+
+		if d.ttl > 0 {
+			info, err := entry.Info()
+			if err != nil || time.Since(info.ModTime()) > d.ttl {
+				// removed meanwhile or expired
+				continue
+			}
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// MarkWithdrawn creates or refreshes the marker file of given service and node
+func (d *DirectoryStore) MarkWithdrawn(service, node string) error {
+	dir := d.serviceDir(service)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	marker := filepath.Join(dir, url.PathEscape(node))
+	if err := os.WriteFile(marker, nil, 0o644); err != nil { //nolint:gosec // markers are not secret
+		return err
+	}
+
+	now := time.Now()
+
+	return os.Chtimes(marker, now, now)
+}
+
+// ClearWithdrawn removes the marker file of given service and node
+func (d *DirectoryStore) ClearWithdrawn(service, node string) error {
+	err := os.Remove(filepath.Join(d.serviceDir(service), url.PathEscape(node)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package birdwatcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectoryStore(t *testing.T) {
+	t.Parallel()
+
+	store := NewDirectoryStore(t.TempDir(), 0)
+
+	// nothing withdrawn yet
+	nodes, err := store.Withdrawn("foo")
+	require.NoError(t, err)
+	assert.Empty(t, nodes)
+
+	require.NoError(t, store.MarkWithdrawn("foo", "node1"))
+	require.NoError(t, store.MarkWithdrawn("foo", "node2"))
+	require.NoError(t, store.MarkWithdrawn("foo/bar", "node/3"))
+	// marking twice is fine
+	require.NoError(t, store.MarkWithdrawn("foo", "node1"))
+
+	nodes, err = store.Withdrawn("foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node1", "node2"}, nodes)
+
+	nodes, err = store.Withdrawn("foo/bar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node/3"}, nodes)
+
+	require.NoError(t, store.ClearWithdrawn("foo", "node1"))
+	// clearing twice is fine
+	require.NoError(t, store.ClearWithdrawn("foo", "node1"))
+	require.NoError(t, store.ClearWithdrawn("baz", "node1"))
+
+	nodes, err = store.Withdrawn("foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node2"}, nodes)
+}
+
+func TestDirectoryStoreTTL(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewDirectoryStore(dir, time.Minute)
+
+	require.NoError(t, store.MarkWithdrawn("foo", "node1"))
+	require.NoError(t, store.MarkWithdrawn("foo", "node2"))
+
+	// the marker of a node that went away expires
+	past := time.Now().Add(-2 * time.Minute)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "foo", "node1"), past, past))
+
+	nodes, err := store.Withdrawn("foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node2"}, nodes)
+
+	// marking again refreshes the marker
+	require.NoError(t, store.MarkWithdrawn("foo", "node1"))
+
+	nodes, err = store.Withdrawn("foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node1", "node2"}, nodes)
+}
+
+func TestCircuitBreakerRefresh(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	breaker := newCircuitBreaker(CircuitBreakerConfig{Enabled: true, Store: WithdrawalStoreDirectory, Directory: dir, MaxWithdrawn: 1, Node: "node1", MarkerTTL: time.Minute}, nil)
+
+	require.True(t, breaker.claim("refresh_foo"))
+
+	// pretend the marker was written a while ago
+	marker := filepath.Join(dir, "refresh_foo", "node1")
+	past := time.Now().Add(-50 * time.Second)
+	require.NoError(t, os.Chtimes(marker, past, past))
+
+	breaker.mu.Lock()
+	breaker.withdrawn["refresh_foo"] = past
+	breaker.mu.Unlock()
+
+	breaker.refresh()
+
+	info, err := os.Stat(marker)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), info.ModTime(), 10*time.Second)
+
+	// markers are no longer refreshed once announced again
+	breaker.clearWithdrawn("refresh_foo")
+	breaker.refresh()
+
+	_, err = os.Stat(marker)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCircuitBreakerClaimConcurrently(t *testing.T) {
+	t.Parallel()
+
+	const maxWithdrawn = 2
+
+	dir := t.TempDir()
+	claimed := make([]bool, 10)
+
+	var wg sync.WaitGroup
+
+	for i := range claimed {
+		breaker := newCircuitBreaker(CircuitBreakerConfig{
+			Enabled:      true,
+			Store:        WithdrawalStoreDirectory,
+			Directory:    dir,
+			MaxWithdrawn: maxWithdrawn,
+			Node:         fmt.Sprintf("node%d", i),
+		}, nil)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			claimed[i] = breaker.claim("breaker_claim")
+		}()
+	}
+
+	wg.Wait()
+
+	count := 0
+
+	for _, c := range claimed {
+		if c {
+			count++
+		}
+	}
+
+	// nodes claiming at the same moment may all back off, but never exceed
+	// the maximum
+	assert.LessOrEqual(t, count, maxWithdrawn)
+
+	nodes, err := NewDirectoryStore(dir, 0).Withdrawn("breaker_claim")
+	require.NoError(t, err)
+	assert.Len(t, nodes, count)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	// without circuit breaker, withdrawing is always allowed
+	var disabled *circuitBreaker
+	assert.True(t, disabled.allows("breaker_foo"))
+	disabled.markWithdrawn("breaker_foo")
+	disabled.clearWithdrawn("breaker_foo")
+
+	dir := t.TempDir()
+	node1 := newCircuitBreaker(CircuitBreakerConfig{Enabled: true, Store: WithdrawalStoreDirectory, Directory: dir, MaxWithdrawn: 1, Node: "node1"}, nil)
+	node2 := newCircuitBreaker(CircuitBreakerConfig{Enabled: true, Store: WithdrawalStoreDirectory, Directory: dir, MaxWithdrawn: 1, Node: "node2"}, nil)
+
+	assert.True(t, node1.allows("breaker_foo"))
+	node1.markWithdrawn("breaker_foo")
+
+	// this node withdrawing doesn't count
+	assert.True(t, node1.allows("breaker_foo"))
+
+	// but it does for its peers
+	assert.False(t, node2.allows("breaker_foo"))
+	assert.InEpsilon(t, 1.0, testutil.ToFloat64(breakerTrippedMetric.WithLabelValues("breaker_foo")), 0.00001)
+	assert.InEpsilon(t, 1.0, testutil.ToFloat64(breakerWithdrawnMetric.WithLabelValues("breaker_foo")), 0.00001)
+
+	node1.clearWithdrawn("breaker_foo")
+	assert.True(t, node2.allows("breaker_foo"))
+	assert.Empty(t, testutil.ToFloat64(breakerTrippedMetric.WithLabelValues("breaker_foo")))
+}
+
+func TestHealthCheckCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	_, prefix, _ := parsePrefix("10.1.0.0/24")
+
+	// two nodes running the same service
+	nodes := make([]*HealthCheck, 2)
+	services := make([]*ServiceCheck, 2)
+
+	for i, node := range []string{"node1", "node2"} {
+		services[i] = &ServiceCheck{name: "breaker_bar", FunctionName: "match_breaker", prefixes: []Prefix{prefix}}
+		nodes[i] = NewHealthCheck(Config{CircuitBreaker: CircuitBreakerConfig{
+			Enabled:      true,
+			Store:        WithdrawalStoreDirectory,
+			Directory:    dir,
+			MaxWithdrawn: 1,
+			Node:         node,
+		}})
+		nodes[i].services = []*ServiceCheck{services[i]}
+	}
+
+	sc := make(chan string)
+	go func() {
+		// make sure to read the status channel to prevent blocking handleAction
+		for range sc {
+		}
+	}()
+	defer close(sc)
+
+	transition := func(i int, state ServiceState) {
+		services[i].setState(state)
+		nodes[i].handleAction(services[i].getAction(), sc)
+	}
+
+	transition(0, ServiceStateUp)
+	transition(1, ServiceStateUp)
+
+	// first node may withdraw
+	transition(0, ServiceStateDown)
+	assert.Empty(t, nodes[0].prefixes["match_breaker"].prefixes)
+
+	// second node is refused, since its peer already withdrew
+	transition(1, ServiceStateDown)
+	assert.Len(t, nodes[1].prefixes["match_breaker"].prefixes, 1)
+	assert.Len(t, nodes[1].held, 1)
+	assert.False(t, nodes[1].releaseHeld())
+
+	// once the first node is up again, the second node may withdraw
+	transition(0, ServiceStateUp)
+	assert.True(t, nodes[1].releaseHeld())
+	assert.Empty(t, nodes[1].prefixes["match_breaker"].prefixes)
+	assert.Empty(t, nodes[1].held)
+
+	// which means the first node may no longer withdraw
+	transition(0, ServiceStateDown)
+	assert.Len(t, nodes[0].prefixes["match_breaker"].prefixes, 1)
+}
@@ -0,0 +1,252 @@
+package birdwatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// shell used to run commands with shell = true
+const shellPath = "/bin/sh"
+
+// characters shell control operators and redirections consist of
+const shellOperatorChars = "|&;<>()"
+
+// time to wait for the output of a command to be closed after it was killed
+const commandWaitDelay = time.Second
+
+var errShellWithArgs = errors.New("args can not be combined with shell")
+
+// buildCommand returns the command and its arguments to execute. When shell is
+// set, the command is run by /bin/sh. When args are given, the command is
+// executed as is with those arguments. Otherwise the command is split into its
+// arguments, honoring quotes.
+func buildCommand(command string, args []string, shell bool) ([]string, error) {
+	if command == "" {
+		return nil, errors.New("command is empty")
+	}
+
+	switch {
+	case shell && len(args) > 0:
+		return nil, errShellWithArgs
+	case shell:
+		return []string{shellPath, "-c", command}, nil
+	case len(args) > 0:
+		return append([]string{command}, args...), nil
+	default:
+		return splitCommand(command)
+	}
+}
+
+// splitCommand splits a command line into its arguments like a POSIX shell
+// would, honoring single quotes, double quotes and backslash escapes. Unquoted
+// arguments that are shell operators, such as pipes, lists or redirections,
+// are rejected. Other characters, such as & in a URL, are kept as is and
+// variables are not expanded.
+//
+//nolint:gocognit // it's a small state machine
+func splitCommand(command string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		// whether current holds an argument, which may be an empty quoted string
+		inArg bool
+		// whether current is quoted or escaped in part
+		quoted  bool
+		quote   rune
+		escaped bool
+	)
+
+	endArg := func() error {
+		if !quoted && isShellOperator(current.String()) {
+			return fmt.Errorf("command contains shell operator %q, use shell = true instead", current.String())
+		}
+
+		args = append(args, current.String())
+		current.Reset()
+
+		inArg = false
+		quoted = false
+
+		return nil
+	}
+
+	for _, r := range command {
+		switch {
+		case escaped:
+			// within double quotes, backslash only escapes a few characters
+			if quote == '"' && !strings.ContainsRune("\\\"$`", r) {
+				current.WriteRune('\\')
+			}
+
+			current.WriteRune(r)
+
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArg = true
+			quoted = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+			quoted = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				if err := endArg(); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			current.WriteRune(r)
+
+			inArg = true
+		}
+	}
+
+	if escaped {
+		return nil, errors.New("command ends with an unfinished escape")
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("command has an unterminated %c quote", quote)
+	}
+
+	if inArg {
+		if err := endArg(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(args) == 0 {
+		return nil, errors.New("command is empty")
+	}
+
+	return args, nil
+}
+
+// isShellOperator returns whether given unquoted argument would be a control
+// operator or redirection to a shell, like |, && or 2>&1
+func isShellOperator(arg string) bool {
+	if arg != "" && strings.Trim(arg, shellOperatorChars) == "" {
+		return true
+	}
+
+	// redirections, optionally preceded by a file descriptor, like >/dev/null
+	rest := strings.TrimLeft(arg, "0123456789")
+
+	return strings.HasPrefix(rest, "<") || strings.HasPrefix(rest, ">") || strings.HasPrefix(rest, "&>")
+}
+
+// commandContext returns the command for given arguments, running in its own
+// process group. When ctx is done, the whole group is killed, so children
+// forked by a shell don't keep the command running past its timeout.
+func commandContext(ctx context.Context, commandArgs []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, commandArgs[0], commandArgs[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		if errors.Is(err, syscall.ESRCH) {
+			return os.ErrProcessDone
+		}
+
+		return err
+	}
+
+	// don't wait forever on output held open by processes that left the group
+	cmd.WaitDelay = commandWaitDelay
+
+	return cmd
+}
+
+// lookupCredential resolves given user and group, either names or numeric IDs,
+// into a credential to run commands with. When only a user is given, the
+// primary group of that user is used.
+func lookupCredential(userName, groupName string) (*syscall.Credential, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil //nolint:nilnil // no credential means running as ourselves
+	}
+
+	cred := &syscall.Credential{
+		Uid: uint32(os.Getuid()), //nolint:gosec // uids are never negative
+		Gid: uint32(os.Getgid()), //nolint:gosec // gids are never negative
+	}
+
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			// fall back to a numeric user ID
+			u, err = user.LookupId(userName)
+			if err != nil {
+				return nil, fmt.Errorf("unknown user %s", userName)
+			}
+		}
+
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid for user %s: %w", userName, err)
+		}
+
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gid for user %s: %w", userName, err)
+		}
+
+		cred.Uid = uint32(uid)
+		cred.Gid = uint32(gid)
+	}
+
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			// fall back to a numeric group ID
+			g, err = user.LookupGroupId(groupName)
+			if err != nil {
+				return nil, fmt.Errorf("unknown group %s", groupName)
+			}
+		}
+
+		gid, err := strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gid for group %s: %w", groupName, err)
+		}
+
+		cred.Gid = uint32(gid)
+	}
+
+	return permittedCredential(cred, os.Geteuid(), os.Getegid())
+}
+
+// permittedCredential returns given credential if a process with given
+// effective uid and gid can switch to it, since only root can. Switching to
+// the user and group the process runs as already is left out, as it would
+// still fail dropping the supplementary groups.
+func permittedCredential(cred *syscall.Credential, euid, egid int) (*syscall.Credential, error) {
+	if euid == 0 {
+		return cred, nil
+	}
+
+	if int(cred.Uid) != euid || int(cred.Gid) != egid {
+		return nil, errors.New("user and group can only be changed when running as root")
+	}
+
+	return nil, nil //nolint:nilnil // no credential means running as ourselves
+}
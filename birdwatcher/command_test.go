@@ -0,0 +1,154 @@
+package birdwatcher
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCommand(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		command string
+		args    []string
+		err     string
+	}{
+		{command: "/usr/bin/true", args: []string{"/usr/bin/true"}},
+		{command: "/usr/sbin/birdc configure", args: []string{"/usr/sbin/birdc", "configure"}},
+		{command: "  /bin/check   foo\tbar  ", args: []string{"/bin/check", "foo", "bar"}},
+		{command: `/bin/check "foo bar" 'baz qux'`, args: []string{"/bin/check", "foo bar", "baz qux"}},
+		{command: `/bin/check foo\ bar`, args: []string{"/bin/check", "foo bar"}},
+		{command: `/bin/check "say \"hi\"" 'it\s'`, args: []string{"/bin/check", `say "hi"`, `it\s`}},
+		{command: `/bin/check "a\b"`, args: []string{"/bin/check", `a\b`}},
+		{command: `/bin/check "" ''`, args: []string{"/bin/check", "", ""}},
+		{command: `/bin/check foo"bar"'baz'`, args: []string{"/bin/check", "foobarbaz"}},
+		{command: `/bin/check "a|b"`, args: []string{"/bin/check", "a|b"}},
+		{command: "/usr/bin/curl -s http://localhost/health?a=1&b=2", args: []string{"/usr/bin/curl", "-s", "http://localhost/health?a=1&b=2"}},
+		{command: "/bin/check foo;bar (baz) $HOME", args: []string{"/bin/check", "foo;bar", "(baz)", "$HOME"}},
+		{command: `/bin/check "|" \&\& '>'`, args: []string{"/bin/check", "|", "&&", ">"}},
+		{command: "/bin/check | grep foo", err: `command contains shell operator "|", use shell = true instead`},
+		{command: "/bin/check && /bin/other", err: `command contains shell operator "&&", use shell = true instead`},
+		{command: "/bin/check ;", err: `command contains shell operator ";", use shell = true instead`},
+		{command: "/bin/check >/dev/null", err: `command contains shell operator ">/dev/null", use shell = true instead`},
+		{command: "/bin/check 2>&1", err: `command contains shell operator "2>&1", use shell = true instead`},
+		{command: "/bin/check &", err: `command contains shell operator "&", use shell = true instead`},
+		{command: `/bin/check "foo`, err: `command has an unterminated " quote`},
+		{command: `/bin/check 'foo`, err: `command has an unterminated ' quote`},
+		{command: `/bin/check foo\`, err: "command ends with an unfinished escape"},
+		{command: "   ", err: "command is empty"},
+	}
+
+	for _, tt := range tests {
+		args, err := splitCommand(tt.command)
+		if tt.err != "" {
+			if assert.Error(t, err, tt.command) {
+				assert.Equal(t, tt.err, err.Error(), tt.command)
+			}
+
+			continue
+		}
+
+		if assert.NoError(t, err, tt.command) {
+			assert.Equal(t, tt.args, args, tt.command)
+		}
+	}
+}
+
+func TestBuildCommand(t *testing.T) {
+	t.Parallel()
+
+	args, err := buildCommand("/bin/check foo", nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/bin/check", "foo"}, args)
+
+	// explicit args don't split the command
+	args, err = buildCommand("/opt/my check", []string{"foo bar", "baz"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/opt/my check", "foo bar", "baz"}, args)
+
+	args, err = buildCommand("pgrep haproxy | grep -q .", nil, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/bin/sh", "-c", "pgrep haproxy | grep -q ."}, args)
+
+	_, err = buildCommand("/bin/check", []string{"foo"}, true)
+	assert.ErrorIs(t, err, errShellWithArgs)
+
+	_, err = buildCommand("", nil, false)
+	assert.Error(t, err)
+}
+
+func TestCommandContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// the child forked by the shell holds on to the output, so it should be
+	// killed along with the shell
+	cmd := commandContext(ctx, []string{shellPath, "-c", "sleep 3; true"})
+
+	begin := time.Now()
+	_, err := cmd.Output()
+	require.Error(t, err)
+	assert.Less(t, time.Since(begin), 2*time.Second)
+	assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+}
+
+func TestLookupCredential(t *testing.T) {
+	t.Parallel()
+
+	cred, err := lookupCredential("", "")
+	assert.NoError(t, err)
+	assert.Nil(t, cred)
+
+	// user by name, group defaults to primary group of user
+	cred, err = lookupCredential("root", "")
+	if assert.NoError(t, err) && assert.NotNil(t, cred) {
+		assert.Equal(t, uint32(0), cred.Uid)
+		assert.Equal(t, uint32(0), cred.Gid)
+	}
+
+	// numeric IDs
+	cred, err = lookupCredential("0", "0")
+	if assert.NoError(t, err) && assert.NotNil(t, cred) {
+		assert.Equal(t, uint32(0), cred.Uid)
+		assert.Equal(t, uint32(0), cred.Gid)
+	}
+
+	_, err = lookupCredential("birdwatcher-nonexistent", "")
+	if assert.Error(t, err) {
+		assert.Equal(t, "unknown user birdwatcher-nonexistent", err.Error())
+	}
+
+	_, err = lookupCredential("", "birdwatcher-nonexistent")
+	if assert.Error(t, err) {
+		assert.Equal(t, "unknown group birdwatcher-nonexistent", err.Error())
+	}
+}
+
+func TestPermittedCredential(t *testing.T) {
+	t.Parallel()
+
+	// root can switch to any user and group
+	cred := &syscall.Credential{Uid: 1000, Gid: 1000}
+	permitted, err := permittedCredential(cred, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, cred, permitted)
+
+	// others can only run as themselves
+	permitted, err = permittedCredential(cred, 1000, 1000)
+	require.NoError(t, err)
+	assert.Nil(t, permitted)
+
+	for _, cred := range []*syscall.Credential{{Uid: 0, Gid: 1000}, {Uid: 1000, Gid: 0}} {
+		_, err = permittedCredential(cred, 1000, 1000)
+		if assert.Error(t, err) {
+			assert.Equal(t, "user and group can only be changed when running as root", err.Error())
+		}
+	}
+}
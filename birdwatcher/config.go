@@ -1,39 +1,107 @@
 package birdwatcher
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
 )
 
 // Config holds definitions from configuration file
 type Config struct {
-	ConfigFile    string
-	ReloadCommand string
-	CompatBird213 bool
-	Prometheus    PrometheusConfig
-	Services      map[string]*ServiceCheck
+	Backend             Backend
+	ConfigFile          string
+	ReloadCommand       string
+	ReloadArgs          []string
+	ReloadShell         bool
+	CompatBird213       bool
+	StallTimeout        time.Duration
+	MaxConcurrentChecks int
+	StartJitter         time.Duration
+	PrefixCheck         string
+	MinAnnounced        map[string]MinAnnounced
+	CircuitBreaker      CircuitBreakerConfig
+	Peering             PeeringConfig
+	Log                 LogConfig
+	Prometheus          PrometheusConfig
+	API                 APIConfig
+	Webhooks            []WebhookConfig
+	Include             []string
+	Defaults            *ServiceCheck
+	Templates           map[string]*ServiceCheck
+	Services            map[string]*ServiceCheck
+	// files that were read
+	files []string
+	// metadata of the main configuration file
+	meta toml.MetaData
 }
 
 // PrometheusConfig holds configuration related to prometheus
 type PrometheusConfig struct {
+	Enabled              bool
+	Port                 int
+	Path                 string
+	CheckDurationBuckets []float64
+}
+
+// WebhookConfig holds configuration of a webhook notified on service
+// transitions and reload failures
+type WebhookConfig struct {
+	URL     string
+	Secret  string
+	Timeout time.Duration
+	Retries int
+}
+
+// APIConfig holds configuration related to the HTTP status API
+type APIConfig struct {
 	Enabled bool
 	Port    int
-	Path    string
+	Socket  string
 }
 
+// Backend represents the routing daemon birdwatcher generates configuration for
+type Backend string
+
+const (
+	// BackendBird generates functions for BIRD
+	BackendBird Backend = "bird"
+	// BackendFRR generates prefix-lists for FRRouting
+	BackendFRR Backend = "frr"
+)
+
+// PrefixCheck modes define how problems with prefixes, such as overlapping
+// prefixes, are reported
+const (
+	// PrefixCheckError refuses the configuration
+	PrefixCheckError = "error"
+	// PrefixCheckWarning logs a warning and accepts the configuration
+	PrefixCheckWarning = "warning"
+)
+
 const (
-	defaultConfigFile     = "/etc/bird/birdwatcher.conf"
-	defaultReloadCommand  = "/usr/sbin/birdc configure"
-	defaultPrometheusPort = 9091
-	defaultPrometheusPath = "/metrics"
+	defaultBackend          = BackendBird
+	defaultConfigFile       = "/etc/bird/birdwatcher.conf"
+	defaultReloadCommand    = "/usr/sbin/birdc configure"
+	defaultFRRConfigFile    = "/etc/frr/birdwatcher.conf"
+	defaultFRRReloadCommand = "/usr/bin/vtysh -f"
+	defaultPrometheusPort   = 9091
+	defaultPrometheusPath   = "/metrics"
+
+	defaultWebhookTimeout = 5 * time.Second
+	defaultWebhookRetries = 3
 
 	defaultFunctionName   = "match_route"
-	defaultCheckInterval  = 1
+	defaultCheckInterval  = time.Second
 	defaultServiceTimeout = 10 * time.Second
 	defaultServiceFail    = 1
 	defaultServiceRise    = 1
@@ -46,24 +114,66 @@ func ReadConfig(conf *Config, configFile string) error {
 		return fmt.Errorf("config file %s not found", configFile)
 	}
 
-	if _, err := toml.DecodeFile(configFile, conf); err != nil {
-		errMsg := err.Error()
+	md, err := decodeFile(configFile, conf)
+	if err != nil {
+		return fmt.Errorf("could not parse config: %s", parseErrorMessage(err))
+	}
 
-		var parseErr toml.ParseError
+	conf.files = []string{configFile}
 
-		if errors.As(err, &parseErr) {
-			errMsg = parseErr.ErrorWithPosition()
-		}
+	conf.meta = md
+
+	if conf.Defaults != nil {
+		convertIntervals(md, conf.Defaults, "defaults")
+	}
+
+	for name, t := range conf.Templates {
+		convertIntervals(md, t, "templates", name)
+	}
+
+	for name, s := range conf.Services {
+		s.name = name
+		s.source = configFile
+
+		convertIntervals(md, s, "services", name)
+	}
+
+	if err := applyTemplates(md, conf, conf.Services); err != nil {
+		return err
+	}
 
-		return fmt.Errorf("could not parse config: %s", errMsg)
+	if err := readIncludes(conf, configFile); err != nil {
+		return err
 	}
 
-	if conf.ConfigFile == "" {
-		conf.ConfigFile = defaultConfigFile
+	if conf.Backend == "" {
+		conf.Backend = defaultBackend
+	}
+
+	switch conf.Backend {
+	case BackendBird:
+		if conf.ConfigFile == "" {
+			conf.ConfigFile = defaultConfigFile
+		}
+
+		if conf.ReloadCommand == "" {
+			conf.ReloadCommand = defaultReloadCommand
+		}
+	case BackendFRR:
+		if conf.ConfigFile == "" {
+			conf.ConfigFile = defaultFRRConfigFile
+		}
+
+		// vtysh applies the generated prefix-lists from the config file itself
+		if conf.ReloadCommand == "" {
+			conf.ReloadCommand = defaultFRRReloadCommand + " " + conf.ConfigFile
+		}
+	default:
+		return fmt.Errorf("unknown backend %s", conf.Backend)
 	}
 
-	if conf.ReloadCommand == "" {
-		conf.ReloadCommand = defaultReloadCommand
+	if _, err := buildCommand(conf.ReloadCommand, conf.ReloadArgs, conf.ReloadShell); err != nil {
+		return fmt.Errorf("invalid reload command: %w", err)
 	}
 
 	if conf.Prometheus.Path == "" {
@@ -74,13 +184,75 @@ func ReadConfig(conf *Config, configFile string) error {
 		conf.Prometheus.Port = defaultPrometheusPort
 	}
 
+	if err := validateLogConfig(&conf.Log); err != nil {
+		return err
+	}
+
+	// buckets should be in increasing order
+	for i := 1; i < len(conf.Prometheus.CheckDurationBuckets); i++ {
+		if conf.Prometheus.CheckDurationBuckets[i] <= conf.Prometheus.CheckDurationBuckets[i-1] {
+			return errors.New("prometheus checkdurationbuckets should be in increasing order")
+		}
+	}
+
+	// by default, serve the API on the same port as prometheus
+	if conf.API.Port == 0 {
+		conf.API.Port = conf.Prometheus.Port
+	}
+
+	for i := range conf.Webhooks {
+		if err := validateWebhook(&conf.Webhooks[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := validatePeering(&conf.Peering, conf.API); err != nil {
+		return err
+	}
+
+	if err := validateCircuitBreaker(&conf.CircuitBreaker, conf.Peering); err != nil {
+		return err
+	}
+
+	if conf.MaxConcurrentChecks < 0 {
+		return errors.New("maxconcurrentchecks should not be negative")
+	}
+
+	if conf.StartJitter < 0 {
+		return errors.New("startjitter should not be negative")
+	}
+
+	switch conf.PrefixCheck {
+	case "":
+		conf.PrefixCheck = PrefixCheckError
+	case PrefixCheckError, PrefixCheckWarning:
+	default:
+		return fmt.Errorf("unknown prefixcheck %s, should be error or warning", conf.PrefixCheck)
+	}
+
 	if len(conf.Services) == 0 {
 		return errors.New("no services configured")
 	}
 
-	allPrefixes := map[string]bool{}
+	// services owning each prefix, to report duplicates
+	type prefixOwner struct {
+		service *ServiceCheck
+		prefix  string
+	}
+
+	allPrefixes := map[string]prefixOwner{}
+
+	names := make([]string, 0, len(conf.Services))
+	for name := range conf.Services {
+		names = append(names, name)
+	}
+
+	// walk the services in a fixed order for consistent error messages
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := conf.Services[name]
 
-	for name, s := range conf.Services {
 		// copy service name to ServiceCheck
 		s.name = name
 
@@ -93,40 +265,262 @@ func ReadConfig(conf *Config, configFile string) error {
 			return err
 		}
 
-		// convert all prefixes into ipnets
-		s.prefixes = make([]net.IPNet, len(s.Prefixes))
+		if s.isPassive() && !conf.API.Enabled {
+			return fmt.Errorf("service %s of type passive requires the api to be enabled", name)
+		}
+
+		// a service can't be considered stalled within a regular check
+		if conf.StallTimeout > 0 && conf.StallTimeout <= s.Interval+s.Timeout {
+			return fmt.Errorf("stalltimeout should be larger than interval and timeout of service %s combined", name)
+		}
+
+		// convert all prefixes into prefix patterns
+		s.prefixes = make([]Prefix, len(s.Prefixes))
 		for i, p := range s.Prefixes {
-			_, ipn, err := net.ParseCIDR(p)
+			ip, prefix, err := parsePrefix(p)
 			if err != nil {
 				return fmt.Errorf("could not parse prefix for service %s: %w", name, err)
 			}
 
-			s.prefixes[i] = *ipn
+			// parsing silently masks the host bits
+			if !ip.Equal(prefix.IP) {
+				if err := conf.prefixProblem(fmt.Sprintf("prefix %s of service %s has host bits set, did you mean %s", p, name, prefix)); err != nil {
+					return err
+				}
+			}
+
+			// prefix-lists of FRR only match more specific networks
+			if conf.Backend == BackendFRR && prefix.matchesShorter() {
+				return fmt.Errorf("prefix %s of service %s matches less specific networks, which the frr backend does not support", p, name)
+			}
+
+			s.prefixes[i] = prefix
 
 			// validate whether the prefixes overlap
-			if _, found := allPrefixes[ipn.String()]; found {
-				return fmt.Errorf("duplicate prefix %s found", ipn.String())
+			if owner, found := allPrefixes[prefix.String()]; found {
+				return fmt.Errorf("duplicate prefix %s found in service %s at %s and service %s at %s", prefix.String(),
+					owner.service.name, owner.service.prefixDefinedAt(owner.prefix), name, s.prefixDefinedAt(p))
 			}
 
-			allPrefixes[ipn.String()] = true
+			allPrefixes[prefix.String()] = prefixOwner{service: s, prefix: p}
 		}
+	}
+
+	if err := validatePrefixOverlap(conf); err != nil {
+		return err
+	}
+
+	if err := validateMinAnnounced(conf.MinAnnounced, conf.Services); err != nil {
+		return err
+	}
+
+	return validateDependencies(conf.Services)
+}
+
+// prefixProblem returns given problem with a prefix as error, or logs it as
+// warning when prefixcheck is set to warning
+func (c *Config) prefixProblem(problem string) error {
+	if c.PrefixCheck == PrefixCheckWarning {
+		log.Warning(problem)
 
-		// map name to each search
-		conf.Services[name] = s
+		return nil
+	}
+
+	return errors.New(problem)
+}
+
+// validatePrefixOverlap checks whether any prefix contains another prefix,
+// either of the same or of another service
+func validatePrefixOverlap(conf *Config) error {
+	type servicePrefix struct {
+		service string
+		prefix  net.IPNet
+	}
+
+	names := make([]string, 0, len(conf.Services))
+	for name := range conf.Services {
+		names = append(names, name)
+	}
+
+	// walk the services in a fixed order for consistent error messages
+	sort.Strings(names)
+
+	// prefixes per address family, since those never overlap
+	families := map[int][]servicePrefix{}
+
+	for _, name := range names {
+		for _, p := range conf.Services[name].prefixes {
+			families[len(p.Mask)] = append(families[len(p.Mask)], servicePrefix{service: name, prefix: p.IPNet})
+		}
+	}
+
+	for _, family := range []int{net.IPv4len, net.IPv6len} {
+		all := families[family]
+
+		// sort the prefixes by network and less specific prefixes first, so
+		// the prefixes containing a prefix come right before it
+		sort.SliceStable(all, func(i, j int) bool {
+			if c := bytes.Compare(all[i].prefix.IP.To16(), all[j].prefix.IP.To16()); c != 0 {
+				return c < 0
+			}
+
+			iOnes, _ := all[i].prefix.Mask.Size()
+			jOnes, _ := all[j].prefix.Mask.Size()
+
+			return iOnes < jOnes
+		})
+
+		// prefixes containing the current prefix, from less to more specific
+		var open []servicePrefix
+
+		for _, inner := range all {
+			for len(open) > 0 && !prefixesOverlap(open[len(open)-1].prefix, inner.prefix) {
+				open = open[:len(open)-1]
+			}
+
+			for _, outer := range open {
+				if err := conf.prefixProblem(fmt.Sprintf("prefix %s of service %s is contained in prefix %s of service %s",
+					inner.prefix.String(), inner.service, outer.prefix.String(), outer.service)); err != nil {
+					return err
+				}
+			}
+
+			open = append(open, inner)
+		}
+	}
+
+	return nil
+}
+
+// prefixesOverlap returns whether one of given prefixes contains the other
+func prefixesOverlap(a, b net.IPNet) bool {
+	// prefixes of different address families never overlap
+	if len(a.Mask) != len(b.Mask) {
+		return false
+	}
+
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// parseErrorMessage returns the message of given decoding error, including
+// the position of parse errors
+func parseErrorMessage(err error) string {
+	var parseErr toml.ParseError
+
+	if errors.As(err, &parseErr) {
+		return parseErr.ErrorWithPosition()
+	}
+
+	return err.Error()
+}
+
+// convertIntervals converts the intervals of the service settings in the
+// table at given path given in whole seconds, as intervals used to be, into
+// durations
+func convertIntervals(md toml.MetaData, s *ServiceCheck, path ...string) {
+	for _, key := range md.Keys() {
+		name, ok := tableKey(key, path)
+		if !ok || md.Type(key...) != "Integer" {
+			continue
+		}
+
+		switch name {
+		case "interval":
+			s.Interval *= time.Second
+		case "fastinterval":
+			s.FastInterval *= time.Second
+		}
+	}
+}
+
+func validateWebhook(wh *WebhookConfig) error {
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook has invalid url %q", wh.URL)
+	}
+
+	if wh.Timeout <= 0 {
+		wh.Timeout = defaultWebhookTimeout
+	}
+
+	if wh.Retries < 0 {
+		return fmt.Errorf("webhook %s has negative retries", wh.URL)
+	}
+
+	if wh.Retries == 0 {
+		wh.Retries = defaultWebhookRetries
 	}
 
 	return nil
 }
 
 func validateService(s *ServiceCheck) error {
-	if s.Command == "" {
-		return fmt.Errorf("service %s has no command set", s.name)
+	switch s.Type {
+	case "", ServiceTypeActive:
+	case ServiceTypePassive:
+		if err := validatePassive(s); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("service %s has unknown type %s", s.name, s.Type)
 	}
 
+	switch {
+	case s.isPassive():
+		// the state of passive services is pushed to them
+	case s.Depends != "":
+		// composite services derive their state from other services
+		if s.Command != "" {
+			return fmt.Errorf("service %s can not have both command and depends set", s.name)
+		}
+
+		expr, err := parseDependencies(s.Depends)
+		if err != nil {
+			return fmt.Errorf("service %s has invalid depends: %w", s.name, err)
+		}
+
+		s.depends = expr
+	default:
+		if s.Command == "" {
+			return fmt.Errorf("service %s has no command set", s.name)
+		}
+
+		if _, err := buildCommand(s.Command, s.Args, s.Shell); err != nil {
+			return fmt.Errorf("service %s has invalid command: %w", s.name, err)
+		}
+	}
+
+	if s.WorkDir != "" {
+		if fi, err := os.Stat(s.WorkDir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("service %s has workdir %s which is not a directory", s.name, s.WorkDir)
+		}
+	}
+
+	for k := range s.Env {
+		if k == "" || strings.ContainsAny(k, "=\x00") {
+			return fmt.Errorf("service %s has invalid environment variable name %q", s.name, k)
+		}
+	}
+
+	cred, err := lookupCredential(s.User, s.Group)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", s.name, err)
+	}
+
+	s.credential = cred
+
 	if s.Interval <= 0 {
 		s.Interval = defaultCheckInterval
 	}
 
+	if s.FastInterval < 0 || s.FastInterval > s.Interval {
+		return fmt.Errorf("service %s should have a fastinterval between 0 and its interval", s.name)
+	}
+
+	if s.FastInterval == 0 {
+		s.FastInterval = s.Interval
+	}
+
 	if s.Timeout <= 0 {
 		s.Timeout = defaultServiceTimeout
 	}
@@ -139,13 +533,71 @@ func validateService(s *ServiceCheck) error {
 		s.Rise = defaultServiceRise
 	}
 
+	if s.PrefixFile != "" {
+		// relative to the file the service is defined in, rather than to the
+		// working directory, which is / when running under systemd
+		if !filepath.IsAbs(s.PrefixFile) && s.source != "" {
+			s.PrefixFile = filepath.Join(filepath.Dir(s.source), s.PrefixFile)
+		}
+
+		prefixes, err := readPrefixFile(s.PrefixFile)
+		if err != nil {
+			return fmt.Errorf("service %s has invalid prefixfile: %w", s.name, err)
+		}
+
+		s.Prefixes = append(s.Prefixes, prefixes...)
+	}
+
 	if len(s.Prefixes) == 0 {
 		return fmt.Errorf("service %s has no prefixes set", s.name)
 	}
 
+	if err := s.ExitCodes.validate(); err != nil {
+		return fmt.Errorf("service %s has invalid exit codes: %w", s.name, err)
+	}
+
+	if s.LogLevel != "" {
+		level, err := log.ParseLevel(s.LogLevel)
+		if err != nil {
+			return fmt.Errorf("service %s has invalid log level: %w", s.name, err)
+		}
+
+		s.logLevel = &level
+	}
+
 	return nil
 }
 
+// readPrefixFile reads the prefixes from given file, containing one prefix
+// per line. Empty lines and comments starting with # are ignored.
+func readPrefixFile(filename string) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []string
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		// report the line of invalid prefixes, they're parsed again along with
+		// the other prefixes of the service
+		if _, _, err := parsePrefix(line); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", filename, i+1, err)
+		}
+
+		prefixes = append(prefixes, line)
+	}
+
+	return prefixes, nil
+}
+
 // GetServices converts the services map into a slice of ServiceChecks and returns it
 func (c Config) GetServices() []*ServiceCheck {
 	sc := make([]*ServiceCheck, len(c.Services))
@@ -1,10 +1,14 @@
 package birdwatcher
 
 import (
+	"net"
+	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -78,7 +82,400 @@ func TestConfig(t *testing.T) {
 
 		err := ReadConfig(&Config{}, "testdata/config/service_duplicateprefix")
 		if assert.Error(t, err) {
-			assert.Regexp(t, regexp.MustCompile("^duplicate prefix .+ found"), err.Error())
+			assert.Equal(t, "duplicate prefix 192.168.0.0/24 found in service bar at testdata/config/service_duplicateprefix:8 and service foo at testdata/config/service_duplicateprefix:4", err.Error())
+		}
+	})
+
+	// check for error for service with prefix contained in that of another
+	t.Run("overlapping prefix", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/service_overlappingprefix")
+		if assert.Error(t, err) {
+			assert.Equal(t, "prefix 10.0.1.0/24 of service bar is contained in prefix 10.0.0.0/16 of service foo", err.Error())
+		}
+	})
+
+	// check for error for prefix with host bits set
+	t.Run("prefix with host bits", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/service_hostbits")
+		if assert.Error(t, err) {
+			assert.Equal(t, "prefix 192.168.0.1/24 of service foo has host bits set, did you mean 192.168.0.0/24", err.Error())
+		}
+	})
+
+	// check whether prefix patterns are parsed
+	t.Run("prefix ranges", func(t *testing.T) {
+		t.Parallel()
+
+		testConf := Config{}
+
+		err := ReadConfig(&testConf, "testdata/config/prefixranges")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		if assert.Len(t, testConf.Services["foo"].prefixes, 2) {
+			assert.Equal(t, "10.0.0.0/16{24,32}", testConf.Services["foo"].prefixes[0].String())
+			assert.Equal(t, "2001:db8::/32+", testConf.Services["foo"].prefixes[1].String())
+		}
+
+		if assert.Len(t, testConf.Services["bar"].prefixes, 1) {
+			assert.Equal(t, "192.168.0.0/16-", testConf.Services["bar"].prefixes[0].String())
+		}
+	})
+
+	// check for error for prefix range the frr backend can't express
+	t.Run("frr prefix range shorter", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/frr_prefixrangeshorter")
+		if assert.Error(t, err) {
+			assert.Equal(t, "prefix 10.0.0.0/16- of service foo matches less specific networks, which the frr backend does not support", err.Error())
+		}
+	})
+
+	// check whether prefixes are read from the prefix file
+	t.Run("prefix file", func(t *testing.T) {
+		t.Parallel()
+
+		testConf := Config{}
+
+		err := ReadConfig(&testConf, "testdata/config/prefixfile")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, []string{"192.168.0.0/24", "192.168.1.0/24", "192.168.2.0/24", "2001:db8::/32+"}, testConf.Services["foo"].Prefixes)
+		assert.Len(t, testConf.Services["foo"].prefixes, 4)
+	})
+
+	// check whether a relative prefix file is read relative to the config
+	// file, rather than to the working directory
+	t.Run("prefix file relative", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		configFile := filepath.Join(dir, "birdwatcher.conf")
+
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, "prefixes"), 0o700))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "prefixes", "foo"), []byte("10.0.0.0/24\n"), 0o600))
+		assert.NoError(t, os.WriteFile(configFile, []byte("[services.foo]\ncommand = \"/usr/bin/true\"\nprefixfile = \"prefixes/foo\"\n"), 0o600))
+
+		testConf := Config{}
+
+		err := ReadConfig(&testConf, configFile)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, filepath.Join(dir, "prefixes", "foo"), testConf.Services["foo"].PrefixFile)
+		assert.Equal(t, []string{"10.0.0.0/24"}, testConf.Services["foo"].Prefixes)
+	})
+
+	// check for error for prefix file with invalid prefix
+	t.Run("prefix file invalid", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/prefixfile_invalid")
+		if assert.Error(t, err) {
+			assert.Regexp(t, regexp.MustCompile("^service foo has invalid prefixfile: testdata/prefixes/invalid line 3: "), err.Error())
+		}
+	})
+
+	// check for error for prefix in prefix file duplicating that of another
+	// service
+	t.Run("prefix file duplicate", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/prefixfile_duplicate")
+		if assert.Error(t, err) {
+			assert.Equal(t, "duplicate prefix 192.168.2.0/24 found in service bar at testdata/config/prefixfile_duplicate:8 and service foo at testdata/prefixes/valid:3", err.Error())
+		}
+	})
+
+	// check whether problems with prefixes are accepted in warning mode
+	t.Run("prefix check warning", func(t *testing.T) {
+		t.Parallel()
+
+		testConf := Config{}
+
+		err := ReadConfig(&testConf, "testdata/config/prefixcheck_warning")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, PrefixCheckWarning, testConf.PrefixCheck)
+
+		if assert.Len(t, testConf.Services["bar"].prefixes, 1) {
+			assert.Equal(t, "192.168.0.0/24", testConf.Services["bar"].prefixes[0].String())
+		}
+	})
+
+	// check for error for unknown backend
+	t.Run("invalid backend", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/invalidbackend")
+		if assert.Error(t, err) {
+			assert.Equal(t, "unknown backend quagga", err.Error())
+		}
+	})
+
+	// read FRR config and check backend specific defaults
+	t.Run("frr backend", func(t *testing.T) {
+		t.Parallel()
+
+		testConf := Config{}
+
+		err := ReadConfig(&testConf, "testdata/config/frr")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, BackendFRR, testConf.Backend)
+		assert.Equal(t, defaultFRRConfigFile, testConf.ConfigFile)
+		assert.Equal(t, "/usr/bin/vtysh -f /etc/frr/birdwatcher.conf", testConf.ReloadCommand)
+	})
+
+	// check for error for webhook with invalid url
+	t.Run("webhook invalid url", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/webhook_invalidurl")
+		if assert.Error(t, err) {
+			assert.Equal(t, `webhook has invalid url "foobar"`, err.Error())
+		}
+	})
+
+	// check for error for unordered histogram buckets
+	t.Run("unordered buckets", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/prometheus_unorderedbuckets")
+		if assert.Error(t, err) {
+			assert.Equal(t, "prometheus checkdurationbuckets should be in increasing order", err.Error())
+		}
+	})
+
+	// check for error for service with invalid log level
+	t.Run("service invalid log level", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/service_invalidloglevel")
+		if assert.Error(t, err) {
+			assert.Regexp(t, regexp.MustCompile("^service foo has invalid log level"), err.Error())
+		}
+	})
+
+	// check for error when stall timeout is within a regular check
+	t.Run("stall timeout too low", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/stalltimeout_toolow")
+		if assert.Error(t, err) {
+			assert.Equal(t, "stalltimeout should be larger than interval and timeout of service foo combined", err.Error())
+		}
+	})
+
+	// check for error for service with exit code mapped twice
+	t.Run("service invalid exit codes", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/service_invalidexitcodes")
+		if assert.Error(t, err) {
+			assert.Equal(t, "service foo has invalid exit codes: exit code 1 mapped to multiple outcomes", err.Error())
+		}
+	})
+
+	// check for error for service with unparsable command
+	t.Run("service invalid command", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/service_invalidcommand")
+		if assert.Error(t, err) {
+			assert.Equal(t, "service foo has invalid command: command has an unterminated ' quote", err.Error())
+		}
+	})
+
+	// check for error for service with missing working directory
+	t.Run("service invalid workdir", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/service_invalidworkdir")
+		if assert.Error(t, err) {
+			assert.Equal(t, "service foo has workdir /nonexistent/birdwatcher which is not a directory", err.Error())
+		}
+	})
+
+	// check for error for service running as unknown user
+	t.Run("service invalid user", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/service_invaliduser")
+		if assert.Error(t, err) {
+			assert.Equal(t, "service foo: unknown user birdwatcher-nonexistent", err.Error())
+		}
+	})
+
+	// check for error for fast interval exceeding interval
+	t.Run("service invalid fast interval", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/service_invalidfastinterval")
+		if assert.Error(t, err) {
+			assert.Equal(t, "service foo should have a fastinterval between 0 and its interval", err.Error())
+		}
+	})
+
+	// read config with composite service
+	t.Run("composite service", func(t *testing.T) {
+		t.Parallel()
+
+		testConf := Config{}
+		err := ReadConfig(&testConf, "testdata/config/composite")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		if assert.Contains(t, testConf.Services, "frontend") {
+			svc := testConf.Services["frontend"]
+			assert.True(t, svc.isComposite())
+			assert.True(t, svc.dependsOn("dns_b"))
+			assert.False(t, svc.dependsOn("frontend"))
+		}
+
+		assert.False(t, testConf.Services["haproxy"].isComposite())
+	})
+
+	// check for error for composite services depending on each other
+	t.Run("composite service cycle", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/composite_cycle")
+		if assert.Error(t, err) {
+			assert.Equal(t, "services have a dependency cycle: bar -> foo -> bar", err.Error())
+		}
+	})
+
+	// check for error for composite service with command
+	t.Run("composite service with command", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/composite_command")
+		if assert.Error(t, err) {
+			assert.Equal(t, "service foo can not have both command and depends set", err.Error())
+		}
+	})
+
+	// check for error for minimum announced of function that is not used
+	t.Run("min announced unknown function", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/minannounced_unknownfunction")
+		if assert.Error(t, err) {
+			assert.Equal(t, "minannounced set for unknown function match_other", err.Error())
+		}
+	})
+
+	// check for error for peering without status API
+	t.Run("peering without api", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/peering_noapi")
+		if assert.Error(t, err) {
+			assert.Equal(t, "peering requires the api to be enabled", err.Error())
+		}
+	})
+
+	// check for error for circuit breaker without directory
+	t.Run("circuit breaker without directory", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/circuitbreaker_nodirectory")
+		if assert.Error(t, err) {
+			assert.Equal(t, "circuitbreaker has no directory set", err.Error())
+		}
+	})
+
+	// check for error for circuit breaker with negative marker ttl
+	t.Run("circuit breaker negative marker ttl", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/circuitbreaker_negativettl")
+		if assert.Error(t, err) {
+			assert.Equal(t, "circuitbreaker markerttl should not be negative", err.Error())
+		}
+	})
+
+	// read config with passive service
+	t.Run("passive service", func(t *testing.T) {
+		t.Parallel()
+
+		testConf := Config{}
+		err := ReadConfig(&testConf, "testdata/config/passive")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		if assert.Contains(t, testConf.Services, "site") {
+			svc := testConf.Services["site"]
+			assert.True(t, svc.isPassive())
+			assert.Equal(t, 5*time.Minute, svc.TTL)
+			assert.Equal(t, ServiceStateUp, svc.Fallback)
+			assert.NotNil(t, svc.pushes)
+		}
+	})
+
+	// check for error for passive service without status API
+	t.Run("passive service without api", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/passive_noapi")
+		if assert.Error(t, err) {
+			assert.Equal(t, "service site of type passive requires the api to be enabled", err.Error())
+		}
+	})
+
+	// check for error for passive service without ttl
+	t.Run("passive service without ttl", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/passive_nottl")
+		if assert.Error(t, err) {
+			assert.Equal(t, "service site of type passive has no ttl set", err.Error())
+		}
+	})
+
+	// check for error for negative concurrency limit
+	t.Run("negative max concurrent checks", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/maxconcurrentchecks_negative")
+		if assert.Error(t, err) {
+			assert.Equal(t, "maxconcurrentchecks should not be negative", err.Error())
+		}
+	})
+
+	// check for error for negative start jitter
+	t.Run("negative startjitter", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/startjitter_negative")
+		if assert.Error(t, err) {
+			assert.Equal(t, "startjitter should not be negative", err.Error())
+		}
+	})
+
+	// check for error for reload command using shell syntax
+	t.Run("invalid reload command", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/invalidreloadcommand")
+		if assert.Error(t, err) {
+			assert.Equal(t, "invalid reload command: command contains shell operator \"&&\", use shell = true instead", err.Error())
 		}
 	})
 
@@ -93,14 +490,23 @@ func TestConfig(t *testing.T) {
 			return
 		}
 
+		assert.Equal(t, BackendBird, testConf.Backend)
 		assert.Equal(t, defaultConfigFile, testConf.ConfigFile)
 		assert.Equal(t, defaultReloadCommand, testConf.ReloadCommand)
 		assert.False(t, testConf.Prometheus.Enabled)
 		assert.Equal(t, defaultPrometheusPort, testConf.Prometheus.Port)
 		assert.Equal(t, defaultPrometheusPath, testConf.Prometheus.Path)
+		assert.Equal(t, PrefixCheckError, testConf.PrefixCheck)
+		assert.False(t, testConf.CircuitBreaker.Enabled)
+		assert.False(t, testConf.Peering.Enabled)
+		assert.Equal(t, LogFormatText, testConf.Log.Format)
+		assert.Equal(t, LogOutputStdout, testConf.Log.Output)
+		assert.False(t, testConf.API.Enabled)
+		assert.Equal(t, defaultPrometheusPort, testConf.API.Port)
 		assert.Len(t, testConf.Services, 1)
 		assert.Equal(t, "foo", testConf.Services["foo"].name)
 		assert.Equal(t, defaultCheckInterval, testConf.Services["foo"].Interval)
+		assert.Equal(t, defaultCheckInterval, testConf.Services["foo"].FastInterval)
 		assert.Equal(t, defaultFunctionName, testConf.Services["foo"].FunctionName)
 		assert.Equal(t, defaultServiceFail, testConf.Services["foo"].Fail)
 		assert.Equal(t, defaultServiceRise, testConf.Services["foo"].Rise)
@@ -130,11 +536,53 @@ func TestConfig(t *testing.T) {
 
 		assert.Equal(t, "/etc/birdwatcher.conf", testConf.ConfigFile)
 		assert.Equal(t, "/sbin/birdc configure", testConf.ReloadCommand)
+		assert.Equal(t, []string{"-s", "/run/bird/bird.ctl"}, testConf.ReloadArgs)
+		assert.False(t, testConf.ReloadShell)
 		assert.True(t, testConf.CompatBird213)
+		assert.Equal(t, 2*time.Minute, testConf.StallTimeout)
+		assert.Equal(t, 8, testConf.MaxConcurrentChecks)
+		assert.Equal(t, 5*time.Second, testConf.StartJitter)
+		assert.Equal(t, PeeringConfig{
+			Enabled:  true,
+			Node:     "anycast01",
+			Peers:    []string{"http://anycast02:4321", "https://anycast03:4321/"},
+			Secret:   "t0ps3cr3t",
+			Interval: 5 * time.Second,
+			Timeout:  defaultPeeringTimeout,
+		}, testConf.Peering)
+		assert.Equal(t, CircuitBreakerConfig{
+			Enabled:      true,
+			Store:        WithdrawalStoreDirectory,
+			Directory:    "/var/lib/birdwatcher/breaker",
+			MaxWithdrawn: 2,
+			Node:         "anycast01",
+			MarkerTTL:    30 * time.Minute,
+		}, testConf.CircuitBreaker)
+		assert.Equal(t, map[string]MinAnnounced{
+			"foo_bar":     {Count: 1},
+			"match_route": {Percent: 50},
+		}, testConf.MinAnnounced)
+
+		assert.Equal(t, LogFormatJSON, testConf.Log.Format)
+		assert.Equal(t, LogOutputFile, testConf.Log.Output)
+		assert.Equal(t, "/var/log/birdwatcher.log", testConf.Log.File)
 
 		assert.True(t, testConf.Prometheus.Enabled)
 		assert.Equal(t, 1234, testConf.Prometheus.Port)
 		assert.Equal(t, "/something", testConf.Prometheus.Path)
+		assert.Equal(t, []float64{0.1, 1, 10}, testConf.Prometheus.CheckDurationBuckets)
+		assert.True(t, testConf.API.Enabled)
+		assert.Equal(t, 4321, testConf.API.Port)
+		assert.Equal(t, "/run/birdwatcher/api.sock", testConf.API.Socket)
+
+		if assert.Len(t, testConf.Webhooks, 2) {
+			assert.Equal(t, "https://example.com/hook", testConf.Webhooks[0].URL)
+			assert.Equal(t, "s3cr3t", testConf.Webhooks[0].Secret)
+			assert.Equal(t, time.Second, testConf.Webhooks[0].Timeout)
+			assert.Equal(t, 5, testConf.Webhooks[0].Retries)
+			assert.Equal(t, defaultWebhookTimeout, testConf.Webhooks[1].Timeout)
+			assert.Equal(t, defaultWebhookRetries, testConf.Webhooks[1].Retries)
+		}
 		assert.Equal(t, "foo_bar", testConf.Services["foo"].FunctionName)
 
 		if assert.Len(t, testConf.Services["foo"].prefixes, 1) {
@@ -153,11 +601,29 @@ func TestConfig(t *testing.T) {
 			for _, svc := range svcs {
 				switch svc.name {
 				case "foo":
-					assert.Equal(t, 10, svc.Interval)
+					assert.Equal(t, 10*time.Second, svc.Interval)
+					assert.Equal(t, 10*time.Second, svc.FastInterval)
 					assert.Equal(t, 20, svc.Rise)
 					assert.Equal(t, 30, svc.Fail)
 					assert.Equal(t, time.Second*40, svc.Timeout)
+					assert.Equal(t, "debug", svc.LogLevel)
+					assert.Equal(t, []string{"--name", "foo bar"}, svc.Args)
+					assert.Equal(t, "/", svc.WorkDir)
+					assert.Equal(t, map[string]string{"FOO": "bar", "EMPTY": ""}, svc.Env)
+					if assert.NotNil(t, svc.credential) {
+						assert.Equal(t, uint32(0), svc.credential.Uid)
+						assert.Equal(t, uint32(0), svc.credential.Gid)
+					}
+					if assert.NotNil(t, svc.logLevel) {
+						assert.Equal(t, log.DebugLevel, *svc.logLevel)
+					}
 				case "bar":
+					assert.True(t, svc.Shell)
+					assert.Equal(t, 250*time.Millisecond, svc.Interval)
+					assert.Equal(t, 100*time.Millisecond, svc.FastInterval)
+					assert.Nil(t, svc.credential)
+					assert.Equal(t, []int{1}, svc.ExitCodes.Degraded)
+					assert.Equal(t, []int{3}, svc.ExitCodes.Unknown)
 				default:
 					assert.Fail(t, "unexpected service name", "service name: %s", svc.name)
 				}
@@ -165,3 +631,89 @@ func TestConfig(t *testing.T) {
 		}
 	})
 }
+
+func TestValidatePrefixOverlap(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		services map[string][]string
+		err      string
+	}{
+		{
+			name:     "siblings",
+			services: map[string][]string{"a": {"10.0.0.0/24", "10.0.1.0/24", "10.1.0.0/16"}, "b": {"10.0.2.0/24", "11.0.0.0/8"}},
+		},
+		{
+			name:     "address families",
+			services: map[string][]string{"a": {"0.0.0.0/0"}, "b": {"::/0"}},
+		},
+		{
+			name:     "contained",
+			services: map[string][]string{"a": {"10.0.0.0/24", "10.0.1.0/24"}, "b": {"10.0.0.0/16"}},
+			err:      "prefix 10.0.0.0/24 of service a is contained in prefix 10.0.0.0/16 of service b",
+		},
+		{
+			name:     "contained after siblings",
+			services: map[string][]string{"a": {"2001:db8::/32", "2001:db8:1::/48"}, "b": {"2001:db7::/32", "2001:db8:1:1::/64"}},
+			err:      "prefix 2001:db8:1::/48 of service a is contained in prefix 2001:db8::/32 of service a",
+		},
+	}
+
+	for _, test := range tests {
+		conf := Config{PrefixCheck: PrefixCheckError, Services: map[string]*ServiceCheck{}}
+
+		for name, prefixes := range test.services {
+			s := &ServiceCheck{name: name}
+
+			for _, pref := range prefixes {
+				_, prf, _ := parsePrefix(pref)
+				s.prefixes = append(s.prefixes, prf)
+			}
+
+			conf.Services[name] = s
+		}
+
+		err := validatePrefixOverlap(&conf)
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+		} else if assert.Error(t, err, test.name) {
+			assert.Equal(t, test.err, err.Error(), test.name)
+		}
+	}
+
+	// many prefixes are checked without comparing each pair of them
+	conf := Config{PrefixCheck: PrefixCheckError, Services: map[string]*ServiceCheck{"many": {name: "many"}}}
+	for i := range 20000 {
+		conf.Services["many"].prefixes = append(conf.Services["many"].prefixes, Prefix{IPNet: net.IPNet{
+			IP:   net.IPv4(10, byte(i>>8), byte(i), 0).To4(),
+			Mask: net.CIDRMask(24, 32),
+		}})
+	}
+
+	assert.NoError(t, validatePrefixOverlap(&conf))
+}
+
+func TestPrefixesOverlap(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		a, b    string
+		overlap bool
+	}{
+		{"10.0.0.0/16", "10.0.1.0/24", true},
+		{"10.0.1.0/24", "10.0.0.0/16", true},
+		{"10.0.0.0/24", "10.0.1.0/24", false},
+		{"0.0.0.0/0", "192.168.0.0/24", true},
+		{"2001:db8::/32", "2001:db8:1::/48", true},
+		{"2001:db8::/32", "2001:db9::/32", false},
+		{"::/0", "10.0.0.0/8", false},
+	}
+
+	for _, test := range tests {
+		_, a, _ := net.ParseCIDR(test.a)
+		_, b, _ := net.ParseCIDR(test.b)
+
+		assert.Equal(t, test.overlap, prefixesOverlap(*a, *b), "%s and %s", test.a, test.b)
+	}
+}
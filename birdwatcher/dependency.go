@@ -0,0 +1,306 @@
+package birdwatcher
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// dependencyExpr is a boolean expression over the states of other services,
+// such as "haproxy && (dns_a || dns_b)"
+type dependencyExpr interface {
+	// eval evaluates the expression, given a function telling whether a
+	// service is up
+	eval(isUp func(name string) bool) bool
+	// services appends the names of the services referenced in the
+	// expression to given slice
+	services(names []string) []string
+}
+
+type (
+	dependencyService string
+	dependencyNot     struct{ expr dependencyExpr }
+	dependencyAnd     struct{ left, right dependencyExpr }
+	dependencyOr      struct{ left, right dependencyExpr }
+)
+
+func (d dependencyService) eval(isUp func(string) bool) bool {
+	return isUp(string(d))
+}
+
+func (d dependencyService) services(names []string) []string {
+	return append(names, string(d))
+}
+
+func (d dependencyNot) eval(isUp func(string) bool) bool {
+	return !d.expr.eval(isUp)
+}
+
+func (d dependencyNot) services(names []string) []string {
+	return d.expr.services(names)
+}
+
+func (d dependencyAnd) eval(isUp func(string) bool) bool {
+	return d.left.eval(isUp) && d.right.eval(isUp)
+}
+
+func (d dependencyAnd) services(names []string) []string {
+	return d.right.services(d.left.services(names))
+}
+
+func (d dependencyOr) eval(isUp func(string) bool) bool {
+	return d.left.eval(isUp) || d.right.eval(isUp)
+}
+
+func (d dependencyOr) services(names []string) []string {
+	return d.right.services(d.left.services(names))
+}
+
+// dependencyParser is a recursive descent parser for dependency expressions
+// with the following grammar, in order of increasing precedence:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | primary
+//	primary = service | "(" or ")"
+type dependencyParser struct {
+	tokens []string
+	pos    int
+}
+
+// parseDependencies parses given dependency expression
+func parseDependencies(input string) (dependencyExpr, error) {
+	tokens, err := tokenizeDependencies(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("expression is empty")
+	}
+
+	p := &dependencyParser{tokens: tokens}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+
+	return expr, nil
+}
+
+// tokenizeDependencies splits given expression into operators, parentheses
+// and service names
+func tokenizeDependencies(input string) ([]string, error) {
+	var tokens []string
+
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == '!':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '&' || r == '|':
+			if i+1 >= len(runes) || runes[i+1] != r {
+				return nil, fmt.Errorf("unexpected %q, use %q", string(r), string([]rune{r, r}))
+			}
+
+			tokens = append(tokens, string([]rune{r, r}))
+			i += 2
+		case isDependencyNameRune(r):
+			start := i
+			for i < len(runes) && isDependencyNameRune(runes[i]) {
+				i++
+			}
+
+			tokens = append(tokens, string(runes[start:i]))
+		default:
+			return nil, fmt.Errorf("unexpected %q", string(r))
+		}
+	}
+
+	return tokens, nil
+}
+
+// isDependencyNameRune returns whether given rune can be part of a service
+// name in a dependency expression
+func isDependencyNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-.:", r)
+}
+
+func (p *dependencyParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *dependencyParser) parseOr() (dependencyExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "||" {
+		p.pos++
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = dependencyOr{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *dependencyParser) parseAnd() (dependencyExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "&&" {
+		p.pos++
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = dependencyAnd{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *dependencyParser) parseUnary() (dependencyExpr, error) {
+	if p.peek() == "!" {
+		p.pos++
+
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return dependencyNot{expr: expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *dependencyParser) parsePrimary() (dependencyExpr, error) {
+	token := p.peek()
+
+	switch token {
+	case "":
+		return nil, errors.New("unexpected end of expression")
+	case "(":
+		p.pos++
+
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ")" {
+			return nil, errors.New("missing closing parenthesis")
+		}
+
+		p.pos++
+
+		return expr, nil
+	case ")", "&&", "||":
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+
+	p.pos++
+
+	return dependencyService(token), nil
+}
+
+// validateDependencies checks whether all services referenced in the depends
+// expressions of given services exist and whether they don't depend on
+// themselves, directly or through other services
+func validateDependencies(services map[string]*ServiceCheck) error {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+
+	// walk the services in a fixed order for consistent error messages
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := services[name]
+		if s.depends == nil {
+			continue
+		}
+
+		for _, dep := range s.depends.services(nil) {
+			if _, found := services[dep]; !found {
+				return fmt.Errorf("service %s depends on unknown service %s", name, dep)
+			}
+		}
+	}
+
+	// depth first search, keeping track of the current path to report cycles
+	const (
+		_ = iota
+		visiting
+		visited
+	)
+
+	marks := make(map[string]int, len(services))
+
+	var visit func(name string, path []string) error
+
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+
+		switch marks[name] {
+		case visited:
+			return nil
+		case visiting:
+			// only report the services that are part of the cycle
+			cycle := path[slices.Index(path, name):]
+
+			return fmt.Errorf("services have a dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		marks[name] = visiting
+
+		if s := services[name]; s.depends != nil {
+			for _, dep := range s.depends.services(nil) {
+				if err := visit(dep, path); err != nil {
+					return err
+				}
+			}
+		}
+
+		marks[name] = visited
+
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package birdwatcher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDependencies(t *testing.T) {
+	t.Parallel()
+
+	up := map[string]bool{"haproxy": true, "dns_a": false, "dns_b": true, "web-1.example": true}
+	isUp := func(name string) bool { return up[name] }
+
+	tests := []struct {
+		expr     string
+		result   bool
+		services []string
+	}{
+		{expr: "haproxy", result: true, services: []string{"haproxy"}},
+		{expr: "dns_a", result: false, services: []string{"dns_a"}},
+		{expr: "!dns_a", result: true, services: []string{"dns_a"}},
+		{expr: "haproxy && (dns_a || dns_b)", result: true, services: []string{"haproxy", "dns_a", "dns_b"}},
+		{expr: "haproxy && dns_a || dns_b", result: true, services: []string{"haproxy", "dns_a", "dns_b"}},
+		{expr: "dns_b || haproxy && dns_a", result: true, services: []string{"dns_b", "haproxy", "dns_a"}},
+		{expr: "(dns_b || haproxy) && dns_a", result: false, services: []string{"dns_b", "haproxy", "dns_a"}},
+		{expr: "!(haproxy && dns_b)", result: false, services: []string{"haproxy", "dns_b"}},
+		{expr: "!!haproxy", result: true, services: []string{"haproxy"}},
+		{expr: "web-1.example&&haproxy", result: true, services: []string{"web-1.example", "haproxy"}},
+		{expr: "unknown", result: false, services: []string{"unknown"}},
+	}
+
+	for _, tt := range tests {
+		expr, err := parseDependencies(tt.expr)
+		if assert.NoError(t, err, tt.expr) {
+			assert.Equal(t, tt.result, expr.eval(isUp), tt.expr)
+			assert.Equal(t, tt.services, expr.services(nil), tt.expr)
+		}
+	}
+
+	errs := []struct {
+		expr string
+		err  string
+	}{
+		{expr: "", err: "expression is empty"},
+		{expr: "  ", err: "expression is empty"},
+		{expr: "haproxy &", err: `unexpected "&", use "&&"`},
+		{expr: "haproxy | dns_a", err: `unexpected "|", use "||"`},
+		{expr: "haproxy &&", err: "unexpected end of expression"},
+		{expr: "(haproxy", err: "missing closing parenthesis"},
+		{expr: "haproxy)", err: `unexpected ")"`},
+		{expr: "haproxy dns_a", err: `unexpected "dns_a"`},
+		{expr: "|| haproxy", err: `unexpected "||"`},
+		{expr: "haproxy && $dns", err: `unexpected "$"`},
+	}
+
+	for _, tt := range errs {
+		_, err := parseDependencies(tt.expr)
+		if assert.Error(t, err, tt.expr) {
+			assert.Equal(t, tt.err, err.Error(), tt.expr)
+		}
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	t.Parallel()
+
+	services := func(depends map[string]string) map[string]*ServiceCheck {
+		svcs := make(map[string]*ServiceCheck, len(depends))
+		for name, expr := range depends {
+			svcs[name] = &ServiceCheck{name: name, Depends: expr}
+			if expr != "" {
+				svcs[name].depends, _ = parseDependencies(expr)
+			}
+		}
+
+		return svcs
+	}
+
+	// diamond shaped dependencies are fine
+	err := validateDependencies(services(map[string]string{
+		"a": "", "b": "a", "c": "a", "d": "b && c",
+	}))
+	assert.NoError(t, err)
+
+	err = validateDependencies(services(map[string]string{
+		"a": "", "b": "a && c",
+	}))
+	if assert.Error(t, err) {
+		assert.Equal(t, "service b depends on unknown service c", err.Error())
+	}
+
+	err = validateDependencies(services(map[string]string{
+		"a": "a",
+	}))
+	if assert.Error(t, err) {
+		assert.Equal(t, "services have a dependency cycle: a -> a", err.Error())
+	}
+
+	err = validateDependencies(services(map[string]string{
+		"a": "", "b": "a || d", "c": "b", "d": "c",
+	}))
+	if assert.Error(t, err) {
+		assert.Equal(t, "services have a dependency cycle: b -> d -> c -> b", err.Error())
+	}
+}
@@ -0,0 +1,138 @@
+package birdwatcher
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// CheckOutcome represents how the result of a check is interpreted
+type CheckOutcome string
+
+const (
+	// CheckOutcomeSuccess counts towards the service going up
+	CheckOutcomeSuccess CheckOutcome = "success"
+	// CheckOutcomeDegraded counts towards the service going up as well, but is
+	// reported separately
+	CheckOutcomeDegraded CheckOutcome = "degraded"
+	// CheckOutcomeFailure counts towards the service going down
+	CheckOutcomeFailure CheckOutcome = "failure"
+	// CheckOutcomeUnknown is ignored, the check could not determine the health
+	// of the service
+	CheckOutcomeUnknown CheckOutcome = "unknown"
+)
+
+// ExitCodePresetNagios maps the exit codes of Nagios plugins: 0 is OK,
+// 1 is WARNING, 2 is CRITICAL and 3 is UNKNOWN
+const ExitCodePresetNagios = "nagios"
+
+// highest exit code a process can return
+const maxExitCode = 255
+
+// ExitCodes maps exit codes of the check command to check outcomes. Exit code
+// 0 is considered a success and any other exit code a failure, unless mapped
+// otherwise.
+type ExitCodes struct {
+	Success  []int
+	Degraded []int
+	Failure  []int
+	Unknown  []int
+}
+
+// UnmarshalTOML decodes either the name of a preset or a table with a list of
+// exit codes per outcome
+func (e *ExitCodes) UnmarshalTOML(data any) error {
+	switch v := data.(type) {
+	case string:
+		if v != ExitCodePresetNagios {
+			return fmt.Errorf("unknown exit code preset %s", v)
+		}
+
+		*e = ExitCodes{
+			Success:  []int{0},
+			Degraded: []int{1},
+			Failure:  []int{2},
+			Unknown:  []int{3},
+		}
+	case map[string]any:
+		for key, value := range v {
+			codes, err := exitCodeList(value)
+			if err != nil {
+				return fmt.Errorf("invalid exit codes for %s: %w", key, err)
+			}
+
+			switch CheckOutcome(key) {
+			case CheckOutcomeSuccess:
+				e.Success = codes
+			case CheckOutcomeDegraded:
+				e.Degraded = codes
+			case CheckOutcomeFailure:
+				e.Failure = codes
+			case CheckOutcomeUnknown:
+				e.Unknown = codes
+			default:
+				return fmt.Errorf("unknown check outcome %s", key)
+			}
+		}
+	default:
+		return errors.New("exitcodes should be a preset or table")
+	}
+
+	return nil
+}
+
+// exitCodeList converts a decoded TOML array into a list of exit codes
+func exitCodeList(value any) ([]int, error) {
+	list, ok := value.([]any)
+	if !ok {
+		return nil, errors.New("expected array of exit codes")
+	}
+
+	codes := make([]int, len(list))
+
+	for i, v := range list {
+		code, ok := v.(int64)
+		if !ok || code < 0 || code > maxExitCode {
+			return nil, fmt.Errorf("invalid exit code %v", v)
+		}
+
+		codes[i] = int(code)
+	}
+
+	return codes, nil
+}
+
+// validate makes sure no exit code is mapped to multiple outcomes
+func (e ExitCodes) validate() error {
+	seen := map[int]bool{}
+
+	for _, codes := range [][]int{e.Success, e.Degraded, e.Failure, e.Unknown} {
+		for _, code := range codes {
+			if seen[code] {
+				return fmt.Errorf("exit code %d mapped to multiple outcomes", code)
+			}
+
+			seen[code] = true
+		}
+	}
+
+	return nil
+}
+
+// outcome returns the check outcome for given exit code
+func (e ExitCodes) outcome(exitCode int) CheckOutcome {
+	switch {
+	case slices.Contains(e.Success, exitCode):
+		return CheckOutcomeSuccess
+	case slices.Contains(e.Degraded, exitCode):
+		return CheckOutcomeDegraded
+	case slices.Contains(e.Unknown, exitCode):
+		return CheckOutcomeUnknown
+	case slices.Contains(e.Failure, exitCode):
+		return CheckOutcomeFailure
+	case exitCode == 0:
+		return CheckOutcomeSuccess
+	default:
+		return CheckOutcomeFailure
+	}
+}
@@ -0,0 +1,112 @@
+package birdwatcher
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitCodesUnmarshalTOML(t *testing.T) {
+	t.Parallel()
+
+	t.Run("nagios preset", func(t *testing.T) {
+		t.Parallel()
+
+		var v struct{ ExitCodes ExitCodes }
+
+		_, err := toml.Decode(`exitcodes = "nagios"`, &v)
+		require.NoError(t, err)
+		assert.Equal(t, ExitCodes{
+			Success:  []int{0},
+			Degraded: []int{1},
+			Failure:  []int{2},
+			Unknown:  []int{3},
+		}, v.ExitCodes)
+	})
+
+	t.Run("table", func(t *testing.T) {
+		t.Parallel()
+
+		var v struct{ ExitCodes ExitCodes }
+
+		_, err := toml.Decode("[exitcodes]\nsuccess = [0, 4]\nunknown = [99]", &v)
+		require.NoError(t, err)
+		assert.Equal(t, ExitCodes{
+			Success: []int{0, 4},
+			Unknown: []int{99},
+		}, v.ExitCodes)
+	})
+
+	t.Run("unknown preset", func(t *testing.T) {
+		t.Parallel()
+
+		var v struct{ ExitCodes ExitCodes }
+
+		_, err := toml.Decode(`exitcodes = "icinga"`, &v)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "unknown exit code preset icinga")
+		}
+	})
+
+	t.Run("unknown outcome", func(t *testing.T) {
+		t.Parallel()
+
+		var v struct{ ExitCodes ExitCodes }
+
+		_, err := toml.Decode("[exitcodes]\nmeh = [1]", &v)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "unknown check outcome meh")
+		}
+	})
+
+	t.Run("invalid exit code", func(t *testing.T) {
+		t.Parallel()
+
+		var v struct{ ExitCodes ExitCodes }
+
+		_, err := toml.Decode("[exitcodes]\nfailure = [256]", &v)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "invalid exit code 256")
+		}
+	})
+}
+
+func TestExitCodesValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, ExitCodes{}.validate())
+	assert.NoError(t, ExitCodes{Success: []int{0}, Failure: []int{1}}.validate())
+
+	err := ExitCodes{Success: []int{0, 1}, Unknown: []int{1}}.validate()
+	if assert.Error(t, err) {
+		assert.Equal(t, "exit code 1 mapped to multiple outcomes", err.Error())
+	}
+}
+
+func TestExitCodesOutcome(t *testing.T) {
+	t.Parallel()
+
+	// by default, only 0 is a success
+	e := ExitCodes{}
+	assert.Equal(t, CheckOutcomeSuccess, e.outcome(0))
+	assert.Equal(t, CheckOutcomeFailure, e.outcome(1))
+	assert.Equal(t, CheckOutcomeFailure, e.outcome(3))
+
+	e = ExitCodes{
+		Success:  []int{0},
+		Degraded: []int{1},
+		Failure:  []int{2},
+		Unknown:  []int{3},
+	}
+	assert.Equal(t, CheckOutcomeSuccess, e.outcome(0))
+	assert.Equal(t, CheckOutcomeDegraded, e.outcome(1))
+	assert.Equal(t, CheckOutcomeFailure, e.outcome(2))
+	assert.Equal(t, CheckOutcomeUnknown, e.outcome(3))
+	assert.Equal(t, CheckOutcomeFailure, e.outcome(4))
+
+	// 0 can be mapped to something else as well
+	e = ExitCodes{Failure: []int{0}}
+	assert.Equal(t, CheckOutcomeFailure, e.outcome(0))
+}
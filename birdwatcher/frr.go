@@ -0,0 +1,331 @@
+package birdwatcher
+
+import (
+	"bytes"
+	// use embed for embedding the prefix-list template
+	_ "embed"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/frr.tpl
+var frrTemplate string
+
+// step between sequence numbers of prefix-list entries
+const frrSeqStep = 5
+
+// frrPrefixList is a prefix-list as rendered into the FRR configuration. Its
+// entries are added before its stale entries are deleted, so the prefix-list
+// is never empty while it is being applied.
+type frrPrefixList struct {
+	Family  string
+	Name    string
+	Entries []frrEntry
+	Stale   []int
+}
+
+// frrEntry is an entry of a prefix-list
+type frrEntry struct {
+	Seq  int
+	Rule string
+}
+
+// frrState holds the rules per sequence number of the prefix-lists, by family
+// and name, as applied by a previously written configuration file
+type frrState map[string]map[int]string
+
+func updateFRRConfig(config Config, prefixes PrefixCollection) error {
+	// entries of the last written file may be in place as well, even if
+	// reloading it failed, while its deletions may not
+	applied := readFRRState(frrAppliedFile(config))
+	present := mergeFRRState(applied, readFRRState(config.ConfigFile))
+	lists := frrPrefixLists(prefixes, present)
+
+	err := updateConfigFile(config.ConfigFile, func(filename string) error {
+		return writeFRRConfig(filename, lists)
+	})
+
+	// the previous file may still delete stale entries, while the prefix-lists
+	// themselves did not change. Only skip reloading once they were applied.
+	unchanged := frrUnchanged(lists, present) && frrUnchanged(lists, applied)
+
+	switch {
+	case err == nil && unchanged:
+		return errConfigIdentical
+	case errors.Is(err, errConfigIdentical) && !unchanged:
+		return nil
+	}
+
+	return err
+}
+
+// frrAppliedFile returns the file holding a copy of the configuration file
+// FRR last reloaded successfully
+func frrAppliedFile(config Config) string {
+	return config.ConfigFile + ".applied"
+}
+
+// markFRRApplied records the configuration file as applied by FRR, after it
+// was reloaded successfully
+func markFRRApplied(config Config) error {
+	data, err := os.ReadFile(config.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(frrAppliedFile(config), data, 0o600)
+}
+
+// mergeFRRState returns the entries of both given states, preferring the rules
+// of the latter
+func mergeFRRState(applied, written frrState) frrState {
+	state := frrState{}
+
+	for _, s := range []frrState{applied, written} {
+		for key, entries := range s {
+			if state[key] == nil {
+				state[key] = map[int]string{}
+			}
+
+			for seq, rule := range entries {
+				state[key][seq] = rule
+			}
+		}
+	}
+
+	return state
+}
+
+// writeFRRConfig renders given prefix-lists. Since vtysh applies the file on
+// top of the running config, entries are replaced by sequence number instead
+// of clearing the prefix-lists, which would make them deny everything until
+// their entries are added again.
+func writeFRRConfig(filename string, lists []frrPrefixList) error {
+	tmpl := template.Must(template.New("frr").Parse(frrTemplate))
+
+	tplBody := struct {
+		Lists []frrPrefixList
+	}{
+		Lists: lists,
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, tplBody); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, buf.Bytes(), 0o644) //nolint:gosec // config file should be readable by FRR
+}
+
+// frrPrefixLists returns an ip and ipv6 prefix-list per prefix set, keeping
+// the sequence numbers of entries that were applied before
+func frrPrefixLists(prefixes PrefixCollection, applied frrState) []frrPrefixList {
+	names := make([]string, 0, len(prefixes))
+	for name := range prefixes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	lists := make([]frrPrefixList, 0, 2*len(names))
+
+	for _, name := range names {
+		ps := prefixes[name].Prefixes()
+		lists = append(lists,
+			frrList("ip", name, ipv4Prefixes(ps), applied),
+			frrList("ipv6", name, ipv6Prefixes(ps), applied))
+	}
+
+	return lists
+}
+
+// frrList returns the prefix-list of given family and name permitting given
+// prefixes, or denying everything without prefixes
+func frrList(family, name string, prefixes []string, applied frrState) frrPrefixList {
+	rules := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		rules[i] = "permit " + p
+	}
+
+	if len(rules) == 0 {
+		rules = []string{"deny any"}
+	}
+
+	list := frrPrefixList{Family: family, Name: name}
+	previous := applied[family+" "+name]
+
+	// keep the sequence numbers of rules that are already applied, since FRR
+	// refuses the same rule under two sequence numbers
+	used := map[int]bool{}
+	seqs := make([]int, len(rules))
+
+	for i, rule := range rules {
+		for seq, appliedRule := range previous {
+			if appliedRule == rule && !used[seq] {
+				seqs[i] = seq
+				used[seq] = true
+
+				break
+			}
+		}
+	}
+
+	// new rules take the lowest free sequence numbers, replacing stale entries
+	// in place
+	next := 0
+
+	for i := range rules {
+		if seqs[i] != 0 {
+			continue
+		}
+
+		for used[frrSeq(next)] {
+			next++
+		}
+
+		seqs[i] = frrSeq(next)
+		used[seqs[i]] = true
+	}
+
+	for i, rule := range rules {
+		list.Entries = append(list.Entries, frrEntry{Seq: seqs[i], Rule: rule})
+	}
+
+	sort.Slice(list.Entries, func(i, j int) bool {
+		return list.Entries[i].Seq < list.Entries[j].Seq
+	})
+
+	for seq := range previous {
+		if !used[seq] {
+			list.Stale = append(list.Stale, seq)
+		}
+	}
+
+	sort.Ints(list.Stale)
+
+	return list
+}
+
+// frrUnchanged returns whether given prefix-lists are applied already
+func frrUnchanged(lists []frrPrefixList, applied frrState) bool {
+	for _, list := range lists {
+		previous := applied[list.Family+" "+list.Name]
+		if len(list.Stale) > 0 || len(list.Entries) != len(previous) {
+			return false
+		}
+
+		for _, entry := range list.Entries {
+			if previous[entry.Seq] != entry.Rule {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// readFRRState returns the prefix-lists given previously written configuration
+// file applied, or nothing if it can't be read. The file is replayed, so
+// files clearing the prefix-lists, as earlier versions wrote, are read as
+// well.
+func readFRRState(filename string) frrState {
+	state := frrState{}
+
+	for _, line := range readLines(filename) {
+		fields := strings.Fields(line)
+
+		remove := len(fields) > 0 && fields[0] == "no"
+		if remove {
+			fields = fields[1:]
+		}
+
+		if len(fields) < 3 || (fields[0] != "ip" && fields[0] != "ipv6") || fields[1] != "prefix-list" {
+			continue
+		}
+
+		key := fields[0] + " " + fields[2]
+
+		// no ip prefix-list NAME
+		if len(fields) == 3 {
+			if remove {
+				delete(state, key)
+			}
+
+			continue
+		}
+
+		if fields[3] != "seq" || len(fields) < 5 {
+			continue
+		}
+
+		seq, err := strconv.Atoi(fields[4])
+		if err != nil {
+			continue
+		}
+
+		if remove {
+			delete(state[key], seq)
+
+			continue
+		}
+
+		if state[key] == nil {
+			state[key] = map[int]string{}
+		}
+
+		state[key][seq] = strings.Join(fields[5:], " ")
+	}
+
+	return state
+}
+
+// ipv4Prefixes returns the IPv4 prefixes from given list in the notation of FRR
+func ipv4Prefixes(x []Prefix) []string {
+	pp := []string{}
+
+	for _, p := range x {
+		if p.IP.To4() != nil {
+			pp = append(pp, frrPrefix(p))
+		}
+	}
+
+	return pp
+}
+
+// ipv6Prefixes returns the IPv6 prefixes from given list in the notation of FRR
+func ipv6Prefixes(x []Prefix) []string {
+	pp := []string{}
+
+	for _, p := range x {
+		if p.IP.To4() == nil {
+			pp = append(pp, frrPrefix(p))
+		}
+	}
+
+	return pp
+}
+
+// frrPrefix returns given prefix in the notation of FRR prefix-lists, which
+// express the range of matched prefix lengths using ge and le
+func frrPrefix(p Prefix) string {
+	s := p.IPNet.String()
+	if p.Range == nil {
+		return s
+	}
+
+	// ranges matching less specific networks are refused by ReadConfig
+	if ones, _ := p.Mask.Size(); p.Range.Low > ones {
+		s += " ge " + strconv.Itoa(p.Range.Low)
+	}
+
+	return s + " le " + strconv.Itoa(p.Range.High)
+}
+
+// frrSeq returns the sequence number for the prefix-list entry at given index
+func frrSeq(i int) int {
+	return (i + 1) * frrSeqStep
+}
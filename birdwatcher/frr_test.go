@@ -0,0 +1,325 @@
+package birdwatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFRRConfig(t *testing.T) {
+	t.Parallel()
+
+	t.Run("empty config", func(t *testing.T) {
+		t.Parallel()
+
+		filename := filepath.Join(t.TempDir(), "frr_test")
+
+		prefixes := make(PrefixCollection)
+		prefixes["match_route"] = NewPrefixSet("match_route")
+
+		// write frr config with empty prefix list
+		err := writeFRRConfig(filename, frrPrefixLists(prefixes, nil))
+		require.NoError(t, err)
+
+		// read data from temp file and compare it to file fixture
+		data, err := os.ReadFile(filename)
+		require.NoError(t, err)
+
+		fixture, err := os.ReadFile("testdata/frr/config_empty")
+		require.NoError(t, err)
+
+		assert.Equal(t, string(fixture), string(data))
+	})
+
+	t.Run("mixed prefixset", func(t *testing.T) {
+		t.Parallel()
+
+		filename := filepath.Join(t.TempDir(), "frr_test")
+
+		prefixes := make(PrefixCollection)
+		prefixes["match_route"] = NewPrefixSet("match_route")
+
+		for _, pref := range []string{"1.2.3.4/32", "fc00::/7", "2.3.4.5/26", "2001:db8::/32"} {
+			_, prf, _ := parsePrefix(pref)
+			prefixes["match_route"].Add(prf)
+		}
+
+		// write frr config to it
+		err := writeFRRConfig(filename, frrPrefixLists(prefixes, nil))
+		require.NoError(t, err)
+
+		// read data from temp file and compare it to file fixture
+		data, err := os.ReadFile(filename)
+		require.NoError(t, err)
+
+		fixture, err := os.ReadFile("testdata/frr/config")
+		require.NoError(t, err)
+
+		assert.Equal(t, string(fixture), string(data))
+	})
+
+	t.Run("prefix ranges", func(t *testing.T) {
+		t.Parallel()
+
+		filename := filepath.Join(t.TempDir(), "frr_test")
+
+		prefixes := make(PrefixCollection)
+		prefixes["match_route"] = NewPrefixSet("match_route")
+
+		for _, pref := range []string{"10.0.0.0/16{24,32}", "192.168.0.0/24+", "2001:db8::/32{48,64}"} {
+			_, prf, err := parsePrefix(pref)
+			require.NoError(t, err)
+			prefixes["match_route"].Add(prf)
+		}
+
+		// write frr config, which should express the ranges using ge and le
+		err := writeFRRConfig(filename, frrPrefixLists(prefixes, nil))
+		require.NoError(t, err)
+
+		// read data from temp file and compare it to file fixture
+		data, err := os.ReadFile(filename)
+		require.NoError(t, err)
+
+		fixture, err := os.ReadFile("testdata/frr/config_ranges")
+		require.NoError(t, err)
+
+		assert.Equal(t, string(fixture), string(data))
+	})
+}
+
+func TestUpdateFRRConfig(t *testing.T) {
+	t.Parallel()
+
+	config := Config{ConfigFile: filepath.Join(t.TempDir(), "frr.conf")}
+
+	prefixes := make(PrefixCollection)
+	prefixes["match_route"] = NewPrefixSet("match_route")
+
+	// first write should create the file
+	require.NoError(t, updateFRRConfig(config, prefixes))
+	require.NoError(t, markFRRApplied(config))
+
+	// second write with the same prefixes should be detected as identical
+	err := updateFRRConfig(config, prefixes)
+	require.ErrorIs(t, err, errConfigIdentical)
+
+	// temp file should be cleaned up
+	_, err = os.Stat(config.ConfigFile + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	// changing the prefixes should update the file again
+	_, prf, _ := parsePrefix("1.2.3.4/32")
+	prefixes["match_route"].Add(prf)
+	require.NoError(t, updateFRRConfig(config, prefixes))
+	require.NoError(t, markFRRApplied(config))
+
+	// removing a prefix should keep the other entry and delete the stale one
+	_, other, _ := parsePrefix("2.3.4.0/24")
+	prefixes["match_route"].Add(other)
+	require.NoError(t, updateFRRConfig(config, prefixes))
+	require.NoError(t, markFRRApplied(config))
+	prefixes["match_route"].Remove(prf)
+	require.NoError(t, updateFRRConfig(config, prefixes))
+	require.NoError(t, markFRRApplied(config))
+
+	data, err := os.ReadFile(config.ConfigFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "ip prefix-list match_route seq 10 permit 2.3.4.0/24\nno ip prefix-list match_route seq 5\n")
+
+	// the stale entry should be left out next time, without reloading
+	err = updateFRRConfig(config, prefixes)
+	require.ErrorIs(t, err, errConfigIdentical)
+
+	data, err = os.ReadFile(config.ConfigFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "no ip prefix-list")
+}
+
+func TestUpdateFRRConfigFailedReload(t *testing.T) {
+	t.Parallel()
+
+	_, first, _ := parsePrefix("1.2.3.4/32")
+	_, second, _ := parsePrefix("2.3.4.0/24")
+
+	t.Run("withdrawal", func(t *testing.T) {
+		t.Parallel()
+
+		config := Config{ConfigFile: filepath.Join(t.TempDir(), "frr.conf")}
+		prefixes := PrefixCollection{"match_route": NewPrefixSet("match_route")}
+		prefixes["match_route"].Add(first)
+		prefixes["match_route"].Add(second)
+
+		require.NoError(t, updateFRRConfig(config, prefixes))
+		require.NoError(t, markFRRApplied(config))
+
+		// reloading the withdrawal fails, so it is not marked as applied
+		prefixes["match_route"].Remove(first)
+		require.NoError(t, updateFRRConfig(config, prefixes))
+
+		// the withdrawal should be written and reloaded again
+		require.NoError(t, updateFRRConfig(config, prefixes))
+
+		data, err := os.ReadFile(config.ConfigFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "no ip prefix-list match_route seq 5\n")
+
+		require.NoError(t, markFRRApplied(config))
+		require.ErrorIs(t, updateFRRConfig(config, prefixes), errConfigIdentical)
+	})
+
+	t.Run("announcement", func(t *testing.T) {
+		t.Parallel()
+
+		config := Config{ConfigFile: filepath.Join(t.TempDir(), "frr.conf")}
+		prefixes := PrefixCollection{"match_route": NewPrefixSet("match_route")}
+		prefixes["match_route"].Add(first)
+
+		require.NoError(t, updateFRRConfig(config, prefixes))
+		require.NoError(t, markFRRApplied(config))
+
+		// reloading the announcement fails, so it is not marked as applied
+		prefixes["match_route"].Add(second)
+		require.NoError(t, updateFRRConfig(config, prefixes))
+
+		// the announcement should be reloaded again
+		require.NoError(t, updateFRRConfig(config, prefixes))
+
+		// entries of the failed reload may be in place, and are deleted when
+		// withdrawn again
+		prefixes["match_route"].Remove(second)
+		require.NoError(t, updateFRRConfig(config, prefixes))
+
+		data, err := os.ReadFile(config.ConfigFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "no ip prefix-list match_route seq 10\n")
+	})
+}
+
+func TestFRRPrefixLists(t *testing.T) {
+	t.Parallel()
+
+	prefixSet := func(prefixes ...string) PrefixCollection {
+		set := NewPrefixSet("match_route")
+
+		for _, pref := range prefixes {
+			_, prf, err := parsePrefix(pref)
+			require.NoError(t, err)
+			set.Add(prf)
+		}
+
+		return PrefixCollection{"match_route": set}
+	}
+
+	t.Run("replace entries", func(t *testing.T) {
+		t.Parallel()
+
+		applied := frrState{"ip match_route": {5: "permit 1.2.3.0/24", 10: "permit 2.3.4.0/24"}}
+		lists := frrPrefixLists(prefixSet("2.3.4.0/24", "3.4.5.0/24"), applied)
+		require.Len(t, lists, 2)
+
+		// the remaining entry keeps its sequence number, the new entry replaces
+		// the stale one
+		assert.Equal(t, frrPrefixList{
+			Family: "ip",
+			Name:   "match_route",
+			Entries: []frrEntry{
+				{Seq: 5, Rule: "permit 3.4.5.0/24"},
+				{Seq: 10, Rule: "permit 2.3.4.0/24"},
+			},
+		}, lists[0])
+		assert.Equal(t, frrPrefixList{
+			Family:  "ipv6",
+			Name:    "match_route",
+			Entries: []frrEntry{{Seq: 5, Rule: "deny any"}},
+		}, lists[1])
+	})
+
+	t.Run("remove entries", func(t *testing.T) {
+		t.Parallel()
+
+		applied := frrState{"ip match_route": {5: "permit 1.2.3.0/24", 10: "permit 2.3.4.0/24", 15: "permit 3.4.5.0/24"}}
+		lists := frrPrefixLists(prefixSet("3.4.5.0/24"), applied)
+		require.Len(t, lists, 2)
+
+		assert.Equal(t, frrPrefixList{
+			Family:  "ip",
+			Name:    "match_route",
+			Entries: []frrEntry{{Seq: 15, Rule: "permit 3.4.5.0/24"}},
+			Stale:   []int{5, 10},
+		}, lists[0])
+		assert.False(t, frrUnchanged(lists, applied))
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		t.Parallel()
+
+		applied := frrState{
+			"ip match_route":   {5: "permit 1.2.3.0/24"},
+			"ipv6 match_route": {5: "deny any"},
+		}
+		lists := frrPrefixLists(prefixSet("1.2.3.0/24"), applied)
+		assert.True(t, frrUnchanged(lists, applied))
+	})
+}
+
+func TestReadFRRState(t *testing.T) {
+	t.Parallel()
+
+	t.Run("config", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, frrState{
+			"ip match_route":   {5: "permit 1.2.3.4/32", 10: "permit 2.3.4.0/26"},
+			"ipv6 match_route": {5: "permit fc00::/7", 10: "permit 2001:db8::/32"},
+		}, readFRRState("testdata/frr/config"))
+	})
+
+	t.Run("stale entries", func(t *testing.T) {
+		t.Parallel()
+
+		filename := filepath.Join(t.TempDir(), "frr.conf")
+		require.NoError(t, os.WriteFile(filename, []byte("! DO NOT EDIT MANUALLY\n"+
+			"ip prefix-list match_route seq 10 deny any\n"+
+			"no ip prefix-list match_route seq 5\n"), 0o600))
+
+		assert.Equal(t, frrState{"ip match_route": {10: "deny any"}}, readFRRState(filename))
+	})
+
+	t.Run("cleared prefix-list", func(t *testing.T) {
+		t.Parallel()
+
+		// files written by earlier versions clear the prefix-lists first
+		filename := filepath.Join(t.TempDir(), "frr.conf")
+		require.NoError(t, os.WriteFile(filename, []byte("! DO NOT EDIT MANUALLY\n"+
+			"ip prefix-list match_route seq 1 deny any\n"+
+			"no ip prefix-list match_route\n"+
+			"ip prefix-list match_route seq 5 permit 1.2.3.4/32\n"), 0o600))
+
+		assert.Equal(t, frrState{"ip match_route": {5: "permit 1.2.3.4/32"}}, readFRRState(filename))
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Empty(t, readFRRState(filepath.Join(t.TempDir(), "frr.conf")))
+	})
+}
+
+func TestFRRPrefixHelpers(t *testing.T) {
+	t.Parallel()
+
+	prefixes := make([]Prefix, 4)
+
+	for i, pref := range []string{"1.2.3.0/24", "fc00::/7", "3.4.5.0/24", "2001:db8::/32"} {
+		_, prf, _ := parsePrefix(pref)
+		prefixes[i] = prf
+	}
+
+	assert.Equal(t, []string{"1.2.3.0/24", "3.4.5.0/24"}, ipv4Prefixes(prefixes))
+	assert.Equal(t, []string{"fc00::/7", "2001:db8::/32"}, ipv6Prefixes(prefixes))
+	assert.Equal(t, 5, frrSeq(0))
+	assert.Equal(t, 15, frrSeq(2))
+}
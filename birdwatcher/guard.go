@@ -0,0 +1,345 @@
+package birdwatcher
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	guardActiveMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "guard",
+		Name:      "active",
+		Help:      "Whether withdrawals are being refused to keep the minimum number of prefixes announced per function name",
+	}, []string{"function_name"})
+
+	guardHeldMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "guard",
+		Name:      "held_prefixes",
+		Help:      "Number of prefixes kept announced despite their service being down per function name",
+	}, []string{"function_name"})
+
+	guardRefusedMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "guard",
+		Name:      "refused_total",
+		Help:      "Number of withdrawals refused to keep the minimum number of prefixes announced per function name",
+	}, []string{"function_name"})
+)
+
+// MinAnnounced is the minimum number of prefixes to keep announced for a
+// function name, either as an absolute number or as a percentage of the
+// prefixes of all services using that function name
+type MinAnnounced struct {
+	Count   int
+	Percent float64
+}
+
+// UnmarshalTOML decodes either an absolute number or a percentage such as
+// "50%"
+func (m *MinAnnounced) UnmarshalTOML(data any) error {
+	switch v := data.(type) {
+	case int64:
+		if v < 0 {
+			return errors.New("minimum should not be negative")
+		}
+
+		*m = MinAnnounced{Count: int(v)}
+	case string:
+		value, found := strings.CutSuffix(strings.TrimSpace(v), "%")
+		if !found {
+			return fmt.Errorf("invalid minimum %q, should be a number or percentage", v)
+		}
+
+		percent, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return fmt.Errorf("invalid percentage %q", v)
+		}
+
+		*m = MinAnnounced{Percent: percent}
+	default:
+		return errors.New("minimum should be a number or percentage")
+	}
+
+	return nil
+}
+
+// String returns the minimum the way it was configured
+func (m MinAnnounced) String() string {
+	if m.Percent > 0 {
+		return strconv.FormatFloat(m.Percent, 'f', -1, 64) + "%"
+	}
+
+	return strconv.Itoa(m.Count)
+}
+
+// minimum returns the number of prefixes to keep announced out of given total
+func (m MinAnnounced) minimum(total int) int {
+	if m.Percent > 0 {
+		return int(math.Ceil(float64(total) * m.Percent / 100))
+	}
+
+	return m.Count
+}
+
+// validateMinAnnounced checks whether the minimums refer to function names
+// in use and don't exceed the number of prefixes of those function names
+func validateMinAnnounced(minimums map[string]MinAnnounced, services map[string]*ServiceCheck) error {
+	totals := map[string]int{}
+	for _, s := range services {
+		totals[s.FunctionName] += len(s.Prefixes)
+	}
+
+	for functionName, m := range minimums {
+		total, found := totals[functionName]
+		if !found {
+			return fmt.Errorf("minannounced set for unknown function %s", functionName)
+		}
+
+		if m.minimum(total) > total {
+			return fmt.Errorf("minannounced of function %s exceeds its %d prefixes", functionName, total)
+		}
+	}
+
+	return nil
+}
+
+// reasons for holding a prefix
+const (
+	holdReasonMinimum        = "minimum"
+	holdReasonCircuitBreaker = "circuitbreaker"
+)
+
+// heldPrefix is a prefix kept announced by the guard or circuit breaker
+// although its service is down
+type heldPrefix struct {
+	service *ServiceCheck
+	prefix  Prefix
+	reason  string
+}
+
+// minAnnounced returns the number of prefixes to keep announced for given
+// function name, 0 if not configured
+func (h *HealthCheck) minAnnounced(functionName string) int {
+	m, found := h.Config.MinAnnounced[functionName]
+	if !found {
+		return 0
+	}
+
+	total := 0
+	for _, s := range h.services {
+		if s.FunctionName == functionName {
+			total += len(s.prefixes)
+		}
+	}
+
+	return m.minimum(total)
+}
+
+// mayWithdraw returns whether a prefix of given function name can be
+// withdrawn without dropping below its minimum number of announced prefixes.
+// The caller should hold h.mu.
+func (h *HealthCheck) mayWithdraw(functionName string) bool {
+	announced := 0
+	if set, found := h.prefixes[functionName]; found {
+		announced = len(set.prefixes)
+	}
+
+	return announced > h.minAnnounced(functionName)
+}
+
+// isAnnounced returns whether given prefix is announced for given function
+// name. The caller should hold h.mu.
+func (h *HealthCheck) isAnnounced(functionName string, prefix Prefix) bool {
+	set, found := h.prefixes[functionName]
+
+	return found && set.Contains(prefix)
+}
+
+// withdrawPrefix removes the prefix of given service, unless the circuit
+// breaker tripped or this would drop the number of announced prefixes of its
+// function name below the minimum. In that case the prefix is held and kept
+// announced. It returns whether the prefix was withdrawn.
+func (h *HealthCheck) withdrawPrefix(svc *ServiceCheck, prefix Prefix, tripped bool) bool {
+	h.mu.Lock()
+
+	reason := ""
+
+	switch {
+	case !h.isAnnounced(svc.FunctionName, prefix):
+		// nothing to hold
+	case tripped:
+		reason = holdReasonCircuitBreaker
+	case !h.mayWithdraw(svc.FunctionName):
+		reason = holdReasonMinimum
+	}
+
+	if reason == "" {
+		h.mu.Unlock()
+		h.removePrefix(svc, prefix)
+
+		return true
+	}
+
+	if h.held == nil {
+		h.held = make(map[string]heldPrefix)
+	}
+
+	h.held[prefix.String()] = heldPrefix{service: svc, prefix: prefix, reason: reason}
+	h.mu.Unlock()
+
+	pLog := log.WithFields(log.Fields{
+		"service":       svc.Name(),
+		"function_name": svc.FunctionName,
+		"prefix":        prefix.String(),
+	})
+
+	if reason == holdReasonCircuitBreaker {
+		pLog.Error("refusing to withdraw prefix, too many peers withdrew this service already")
+
+		return false
+	}
+
+	guardRefusedMetric.WithLabelValues(svc.FunctionName).Inc()
+	pLog.WithField("minimum", h.Config.MinAnnounced[svc.FunctionName].String()).
+		Error("refusing to withdraw prefix, minimum number of announced prefixes reached")
+
+	return false
+}
+
+// announcePrefix adds the prefix of given service, or stops holding it if it
+// was kept announced
+func (h *HealthCheck) announcePrefix(svc *ServiceCheck, prefix Prefix) {
+	h.mu.Lock()
+
+	if _, found := h.held[prefix.String()]; found {
+		delete(h.held, prefix.String())
+		h.mu.Unlock()
+
+		log.WithFields(log.Fields{
+			"service": svc.Name(),
+			"prefix":  prefix.String(),
+		}).Info("service of held prefix is up again")
+
+		return
+	}
+
+	h.mu.Unlock()
+
+	h.addPrefix(svc, prefix)
+}
+
+// releaseHeld withdraws held prefixes as far as the circuit breaker and the
+// minimum number of announced prefixes allow and updates the guard metrics.
+// It returns whether any prefix was withdrawn.
+func (h *HealthCheck) releaseHeld() bool {
+	// consult the circuit breaker without holding the lock
+	h.mu.RLock()
+
+	tripped := map[string]bool{}
+	for _, held := range h.held {
+		if held.reason == holdReasonCircuitBreaker {
+			tripped[held.service.Name()] = true
+		}
+	}
+
+	h.mu.RUnlock()
+
+	for service := range tripped {
+		tripped[service] = !h.breaker.claim(service)
+	}
+
+	h.mu.Lock()
+
+	// release in a fixed order
+	keys := make([]string, 0, len(h.held))
+	for key := range h.held {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var released []heldPrefix
+
+	for _, key := range keys {
+		held := h.held[key]
+		if held.reason == holdReasonCircuitBreaker && tripped[held.service.Name()] {
+			continue
+		}
+
+		if !h.mayWithdraw(held.service.FunctionName) {
+			// the circuit breaker may allow withdrawing, the minimum doesn't
+			held.reason = holdReasonMinimum
+			h.held[key] = held
+
+			continue
+		}
+
+		// remove the prefix from the set while still holding the lock, so the
+		// next held prefix is checked against the updated set
+		h.prefixes[held.service.FunctionName].Remove(held.prefix)
+		delete(h.held, key)
+
+		released = append(released, held)
+	}
+
+	// count prefixes held by the guard per function name, including function
+	// names that no longer have any
+	heldCount := map[string]int{}
+	for functionName := range h.Config.MinAnnounced {
+		heldCount[functionName] = 0
+	}
+
+	for _, held := range h.held {
+		if held.reason == holdReasonMinimum {
+			heldCount[held.service.FunctionName]++
+		}
+	}
+
+	h.mu.Unlock()
+
+	withdrawn := map[string]bool{}
+
+	for _, held := range released {
+		prefixStateMetric.WithLabelValues(held.service.Name(), held.prefix.String()).Set(0.0)
+
+		log.WithFields(log.Fields{
+			"service": held.service.Name(),
+			"prefix":  held.prefix.String(),
+		}).Warning("withdrawing previously held prefix")
+
+		withdrawn[held.service.Name()] = true
+	}
+
+	for service := range withdrawn {
+		h.breaker.markWithdrawn(service)
+	}
+
+	// give up claims of services of which no prefix was withdrawn after all
+	for service, refused := range tripped {
+		if !refused && !withdrawn[service] {
+			h.breaker.clearWithdrawn(service)
+		}
+	}
+
+	for functionName, count := range heldCount {
+		guardHeldMetric.WithLabelValues(functionName).Set(float64(count))
+
+		if count > 0 {
+			guardActiveMetric.WithLabelValues(functionName).Set(1)
+		} else {
+			guardActiveMetric.WithLabelValues(functionName).Set(0)
+		}
+	}
+
+	return len(released) > 0
+}
@@ -0,0 +1,138 @@
+package birdwatcher
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinAnnouncedUnmarshalTOML(t *testing.T) {
+	t.Parallel()
+
+	var conf struct {
+		MinAnnounced map[string]MinAnnounced
+	}
+
+	_, err := toml.Decode(`
+[minannounced]
+foo = 2
+bar = "50%"
+baz = " 12.5 % "
+`, &conf)
+	if assert.NoError(t, err) {
+		assert.Equal(t, MinAnnounced{Count: 2}, conf.MinAnnounced["foo"])
+		assert.Equal(t, MinAnnounced{Percent: 50}, conf.MinAnnounced["bar"])
+		assert.Equal(t, MinAnnounced{Percent: 12.5}, conf.MinAnnounced["baz"])
+		assert.Equal(t, "2", conf.MinAnnounced["foo"].String())
+		assert.Equal(t, "50%", conf.MinAnnounced["bar"].String())
+	}
+
+	for input, expected := range map[string]string{
+		`foo = -1`:     "minimum should not be negative",
+		`foo = "50"`:   `invalid minimum "50", should be a number or percentage`,
+		`foo = "150%"`: `invalid percentage "150%"`,
+		`foo = "x%"`:   `invalid percentage "x%"`,
+		`foo = 1.5`:    "minimum should be a number or percentage",
+	} {
+		_, err := toml.Decode("[minannounced]\n"+input, &conf)
+		if assert.Error(t, err, input) {
+			assert.Contains(t, err.Error(), expected, input)
+		}
+	}
+}
+
+func TestMinAnnouncedMinimum(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 2, MinAnnounced{Count: 2}.minimum(10))
+	assert.Equal(t, 5, MinAnnounced{Percent: 50}.minimum(10))
+	// percentages are rounded up
+	assert.Equal(t, 2, MinAnnounced{Percent: 50}.minimum(3))
+	assert.Equal(t, 1, MinAnnounced{Percent: 1}.minimum(3))
+	assert.Equal(t, 0, MinAnnounced{}.minimum(3))
+}
+
+func TestValidateMinAnnounced(t *testing.T) {
+	t.Parallel()
+
+	services := map[string]*ServiceCheck{
+		"foo": {FunctionName: "match_route", Prefixes: []string{"192.168.0.0/24", "192.168.1.0/24"}},
+		"bar": {FunctionName: "match_route", Prefixes: []string{"192.168.2.0/24"}},
+	}
+
+	assert.NoError(t, validateMinAnnounced(map[string]MinAnnounced{"match_route": {Count: 3}}, services))
+	assert.NoError(t, validateMinAnnounced(map[string]MinAnnounced{"match_route": {Percent: 100}}, services))
+
+	err := validateMinAnnounced(map[string]MinAnnounced{"match_route": {Count: 4}}, services)
+	if assert.Error(t, err) {
+		assert.Equal(t, "minannounced of function match_route exceeds its 3 prefixes", err.Error())
+	}
+
+	err = validateMinAnnounced(map[string]MinAnnounced{"other": {Count: 1}}, services)
+	if assert.Error(t, err) {
+		assert.Equal(t, "minannounced set for unknown function other", err.Error())
+	}
+}
+
+func TestHealthCheckGuard(t *testing.T) {
+	t.Parallel()
+
+	services := make([]*ServiceCheck, 3)
+	for i, cidr := range []string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24"} {
+		_, prefix, _ := parsePrefix(cidr)
+		services[i] = &ServiceCheck{name: "guard_" + cidr, FunctionName: "match_guard", prefixes: []Prefix{prefix}}
+	}
+
+	hc := HealthCheck{
+		Config:   Config{MinAnnounced: map[string]MinAnnounced{"match_guard": {Percent: 50}}},
+		services: services,
+	}
+
+	sc := make(chan string)
+	go func() {
+		// make sure to read the status channel to prevent blocking handleAction
+		for range sc {
+		}
+	}()
+	defer close(sc)
+
+	transition := func(s *ServiceCheck, state ServiceState) {
+		s.setState(state)
+		hc.handleAction(s.getAction(), sc)
+	}
+
+	for _, s := range services {
+		transition(s, ServiceStateUp)
+	}
+
+	assert.Len(t, hc.prefixes["match_guard"].prefixes, 3)
+
+	// 50% of 3 prefixes means 2 prefixes should stay announced
+	transition(services[0], ServiceStateDown)
+	assert.Len(t, hc.prefixes["match_guard"].prefixes, 2)
+	assert.Empty(t, testutil.ToFloat64(guardActiveMetric.WithLabelValues("match_guard")))
+
+	// withdrawing the next prefixes is refused
+	transition(services[1], ServiceStateDown)
+	transition(services[2], ServiceStateDown)
+	assert.Len(t, hc.prefixes["match_guard"].prefixes, 2)
+	assert.Len(t, hc.held, 2)
+	assert.InEpsilon(t, 1.0, testutil.ToFloat64(guardActiveMetric.WithLabelValues("match_guard")), 0.00001)
+	assert.InEpsilon(t, 2.0, testutil.ToFloat64(guardHeldMetric.WithLabelValues("match_guard")), 0.00001)
+	assert.InEpsilon(t, 2.0, testutil.ToFloat64(guardRefusedMetric.WithLabelValues("match_guard")), 0.00001)
+
+	// service coming back up allows withdrawing one of the held prefixes
+	transition(services[0], ServiceStateUp)
+	assert.Len(t, hc.prefixes["match_guard"].prefixes, 2)
+	assert.Contains(t, hc.prefixes["match_guard"].prefixes, services[0].prefixes[0])
+	assert.Len(t, hc.held, 1)
+	assert.InEpsilon(t, 1.0, testutil.ToFloat64(guardHeldMetric.WithLabelValues("match_guard")), 0.00001)
+
+	// service of a held prefix coming back up, so nothing is held anymore
+	transition(services[2], ServiceStateUp)
+	assert.Len(t, hc.prefixes["match_guard"].prefixes, 2)
+	assert.Empty(t, hc.held)
+	assert.Empty(t, testutil.ToFloat64(guardActiveMetric.WithLabelValues("match_guard")))
+}
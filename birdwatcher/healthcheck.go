@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net"
-	"os/exec"
+	"math/rand/v2"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,30 +22,109 @@ const (
 	actionsChannelSize = 16
 	// timeout when reloading bird
 	reloadTimeout = 10 * time.Second
+	// interval at which the action loop marks its progress when idle
+	heartbeatInterval = time.Second
+	// additional time allowed for loops to make progress before they are
+	// considered stalled
+	progressGrace = 5 * time.Second
 )
 
-var prefixStateMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
-	Namespace: "birdwatcher",
-	Subsystem: "prefix",
-	Name:      "state",
-	Help:      "Current health state per prefix",
-}, []string{"service", "prefix"})
+// outcomes of reloading the configuration
+const (
+	reloadOutcomeSuccess = "success"
+	reloadOutcomeFailure = "failure"
+	reloadOutcomeTimeout = "timeout"
+)
+
+var (
+	prefixStateMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "prefix",
+		Name:      "state",
+		Help:      "Current health state per prefix",
+	}, []string{"service", "prefix"})
+
+	reloadDurationMetric = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "reload",
+		Name:      "duration_seconds",
+		Help:      "Duration of the reload command in seconds",
+	})
+
+	reloadOutcomeMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "reload",
+		Name:      "total",
+		Help:      "Number of reloads per outcome",
+	}, []string{"outcome"})
+
+	reloadAttemptsMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "reload",
+		Name:      "attempts_total",
+		Help:      "Number of attempts to apply the configuration",
+	})
+
+	reloadSkippedMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "reload",
+		Name:      "skipped_total",
+		Help:      "Number of reloads skipped because the configuration file was identical",
+	})
+
+	reloadLastSuccessMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "reload",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful reload",
+	})
+
+	serviceStalenessMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "service",
+		Name:      "staleness_seconds",
+		Help:      "Time since the last iteration of the check loop per service",
+	}, []string{"service"})
+
+	prefixAnnouncedMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "prefix",
+		Name:      "announced",
+		Help:      "Number of announced prefixes per function name",
+	}, []string{"function_name"})
+)
 
 // HealthCheck -- struct holding everything needed for the never-ending health
 // check loop
 type HealthCheck struct {
 	stopped        chan any
 	actions        chan *Action
-	services       []*ServiceCheck
-	prefixes       PrefixCollection
 	Config         Config
 	reloadedBefore bool
+	notifier       *Notifier
+	breaker        *circuitBreaker
+	peering        *Peering
+	// unix timestamp in nanoseconds of the last iteration of the action loop
+	lastProgress atomic.Int64
+
+	// mu protects services, prefixes and held, which are read by the status API
+	mu       sync.RWMutex
+	services []*ServiceCheck
+	prefixes PrefixCollection
+	// prefixes kept announced by the guard, by prefix
+	held map[string]heldPrefix
 }
 
 // NewHealthCheck returns a HealthCheck with given configuration
-func NewHealthCheck(c Config) HealthCheck {
-	h := HealthCheck{}
+func NewHealthCheck(c Config) *HealthCheck {
+	h := &HealthCheck{}
 	h.Config = c
+	h.notifier = NewNotifier(c.Webhooks)
+	h.peering = NewPeering(c.Peering)
+	h.breaker = newCircuitBreaker(c.CircuitBreaker, h.peering)
+
+	// register check duration histogram with the configured buckets
+	registerCheckDurationMetric(c.Prometheus.CheckDurationBuckets)
 
 	return h
 }
@@ -51,19 +133,66 @@ func NewHealthCheck(c Config) HealthCheck {
 // Actions that come from them
 func (h *HealthCheck) Start(services []*ServiceCheck, ready chan<- bool, status chan string) {
 	// copy reference to services
+	h.mu.Lock()
 	h.services = services
+	h.mu.Unlock()
 	// create channel for service check to push there events on
 	h.actions = make(chan *Action, actionsChannelSize)
 	// create a channel to signal we're stopping
 	h.stopped = make(chan any)
 
+	// deliver notifications in the background
+	if h.notifier != nil {
+		go h.notifier.Start()
+	}
+
+	// exchange service states with peers in the background
+	if h.peering != nil {
+		go h.peering.Start()
+	}
+
+	// mark progress while idle, so a stalled action loop can be detected
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	h.markProgress()
+
+	// limit the number of checks running at the same time
+	var limiter chan struct{}
+	if h.Config.MaxConcurrentChecks > 0 {
+		limiter = make(chan struct{}, h.Config.MaxConcurrentChecks)
+	}
+
 	// start each service and keep a pointer to the services
 	// we'll need this later to stop them
 	for _, s := range services {
+		// composite services are evaluated when their dependencies transition
+		if s.isComposite() {
+			// composite services aren't started, but log their transitions
+			s.setupLogger()
+
+			log.WithFields(log.Fields{
+				"service": s.Name(),
+				"depends": s.Depends,
+			}).Info("starting composite service")
+
+			continue
+		}
+
+		s.limiter = limiter
+		// shift the checks of services by a random delay, capped by their
+		// interval, so they don't all run at the same time
+		if jitter := min(h.Config.StartJitter, s.Interval); jitter > 0 {
+			s.startDelay = rand.N(jitter)
+		}
+
 		log.WithFields(log.Fields{
 			"service": s.Name(),
 		}).Info("starting service check")
 
+		// consider the service to make progress until its first check
+		s.markProgress()
+
 		go s.Start(&h.actions)
 	}
 
@@ -76,6 +205,17 @@ func (h *HealthCheck) Start(services []*ServiceCheck, ready chan<- bool, status
 			log.Debug("received stop signal")
 			// we're done
 			return
+		case <-heartbeat.C:
+			h.supervise(status)
+			h.breaker.refresh()
+
+			// peers may have announced again, allowing held prefixes to be
+			// withdrawn
+			if h.releaseHeld() {
+				h.update(status)
+			}
+
+			h.markProgress()
 		case action := <-h.actions:
 			log.WithFields(log.Fields{
 				"service": action.Service.name,
@@ -83,31 +223,129 @@ func (h *HealthCheck) Start(services []*ServiceCheck, ready chan<- bool, status
 			}).Debug("incoming action")
 
 			h.handleAction(action, status)
+			h.markProgress()
 		}
 	}
 }
 
+// markProgress records the action loop made progress
+func (h *HealthCheck) markProgress() {
+	h.lastProgress.Store(time.Now().UnixNano())
+}
+
+// supervise keeps track of the progress of each service check and forces
+// services down that stalled for longer than the configured stall timeout
+func (h *HealthCheck) supervise(status chan string) {
+	h.mu.RLock()
+	services := h.services
+	h.mu.RUnlock()
+
+	for _, s := range services {
+		// only services running check commands make progress
+		if s.isComposite() || s.isPassive() {
+			continue
+		}
+
+		staleness := s.sinceProgress()
+		serviceStalenessMetric.WithLabelValues(s.Name()).Set(staleness.Seconds())
+
+		if h.Config.StallTimeout <= 0 || staleness <= h.Config.StallTimeout {
+			continue
+		}
+
+		action := s.forceDown()
+		if action == nil {
+			// service is not up, nothing to withdraw
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"service":   s.Name(),
+			"staleness": staleness.Round(time.Second),
+		}).Error("service check stalled, forcing service down")
+
+		h.handleAction(action, status)
+	}
+}
+
+// CheckProgress returns an error if the action loop or any of the service
+// checks stopped making progress
+func (h *HealthCheck) CheckProgress() error {
+	// handling an action may take up to the reload timeout
+	maxDuration := heartbeatInterval + reloadTimeout + progressGrace
+	if since := time.Since(time.Unix(0, h.lastProgress.Load())); since > maxDuration {
+		return fmt.Errorf("action loop made no progress for %s", since.Round(time.Second))
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, s := range h.services {
+		// only services running check commands make progress
+		if s.isComposite() || s.isPassive() {
+			continue
+		}
+
+		if since := s.sinceProgress(); since > s.maxLoopDuration() {
+			return fmt.Errorf("service %s made no progress for %s", s.Name(), since.Round(time.Second))
+		}
+	}
+
+	return nil
+}
+
 func (h *HealthCheck) didReloadBefore() bool {
 	return h.reloadedBefore
 }
 
 func (h *HealthCheck) handleAction(action *Action, status chan string) {
-	for _, p := range action.Prefixes {
-		switch action.State {
-		case ServiceStateUp:
-			h.addPrefix(action.Service, p)
-		case ServiceStateDown:
-			h.removePrefix(action.Service, p)
-		default:
-			log.WithFields(log.Fields{
-				"state":   action.State,
-				"service": action.Service.name,
-			}).Warning("unhandled state received")
+	if action.State != ServiceStateUp && action.State != ServiceStateDown {
+		log.WithFields(log.Fields{
+			"state":   action.State,
+			"service": action.Service.name,
+		}).Warning("unhandled state received")
 
-			return
+		return
+	}
+
+	// composite services depending on this service may transition as well,
+	// handle those in the same reload
+	for _, a := range h.withDependents(action) {
+		if a.State == ServiceStateUp {
+			for _, p := range a.Prefixes {
+				h.announcePrefix(a.Service, p)
+			}
+
+			h.breaker.clearWithdrawn(a.Service.Name())
+		} else {
+			// only withdraw when not too many peers withdrew this service
+			tripped := !h.breaker.claim(a.Service.Name())
+			withdrawn := false
+
+			for _, p := range a.Prefixes {
+				if h.withdrawPrefix(a.Service, p, tripped) {
+					withdrawn = true
+				}
+			}
+
+			// give up the claim if the prefixes were not withdrawn after all
+			if !tripped && !withdrawn {
+				h.breaker.clearWithdrawn(a.Service.Name())
+			}
 		}
+
+		// notify webhooks of the transition
+		h.notifier.Notify(transitionEvent(a))
 	}
 
+	// withdraw prefixes that were held back, if the minimum allows it now
+	h.releaseHeld()
+
+	h.update(status)
+}
+
+// update sends a status update and applies the configuration
+func (h *HealthCheck) update(status chan string) {
 	// gather data for a status update
 	su := h.statusUpdate()
 	log.WithField("status", su).Debug("status update")
@@ -116,9 +354,49 @@ func (h *HealthCheck) handleAction(action *Action, status chan string) {
 
 	if err := h.applyConfig(h.Config, h.prefixes); err != nil {
 		log.WithError(err).Error("could not apply BIRD config")
+
+		h.notifier.Notify(Event{
+			Type:  EventTypeReloadFailure,
+			Error: err.Error(),
+		})
 	}
 }
 
+// withDependents returns given action followed by the actions of composite
+// services that transitioned because of it, directly or through other
+// composite services
+func (h *HealthCheck) withDependents(action *Action) []*Action {
+	h.mu.RLock()
+	services := h.services
+	h.mu.RUnlock()
+
+	isUp := func(name string) bool {
+		for _, s := range services {
+			if s.name == name {
+				return s.IsUp()
+			}
+		}
+
+		return false
+	}
+
+	actions := []*Action{action}
+	// dependency cycles are rejected when reading the config, so this ends
+	for i := 0; i < len(actions); i++ {
+		for _, s := range services {
+			if !s.dependsOn(actions[i].Service.name) {
+				continue
+			}
+
+			if a := s.evaluateDependencies(isUp); a != nil {
+				actions = append(actions, a)
+			}
+		}
+	}
+
+	return actions
+}
+
 // statusUpdate returns a string with a situational report on how many services
 // are configured up
 func (h *HealthCheck) statusUpdate() string {
@@ -154,14 +432,26 @@ func (h *HealthCheck) applyConfig(config Config, prefixes PrefixCollection) erro
 		"file": config.ConfigFile,
 	})
 
-	// update bird config
-	err := updateBirdConfig(config, prefixes)
+	reloadAttemptsMetric.Inc()
+
+	// update config for the configured backend
+	var err error
+
+	switch config.Backend {
+	case BackendFRR:
+		err = updateFRRConfig(config, prefixes)
+	default:
+		err = updateBirdConfig(config, prefixes)
+	}
+
 	if err != nil {
 		// if config did not change, we should still reload if we don't know the
 		// state of BIRD
 		if errors.Is(err, errConfigIdentical) {
 			if h.didReloadBefore() {
 				cLog.Warning("config did not change, not reloading")
+				reloadSkippedMetric.Inc()
+				updateAnnouncedMetric(prefixes)
 
 				return nil
 			}
@@ -170,6 +460,7 @@ func (h *HealthCheck) applyConfig(config Config, prefixes PrefixCollection) erro
 		} else {
 			// break on any other error
 			cLog.WithError(err).Warning("error updating configuration")
+			reloadOutcomeMetric.WithLabelValues(reloadOutcomeFailure).Inc()
 
 			return err
 		}
@@ -180,33 +471,51 @@ func (h *HealthCheck) applyConfig(config Config, prefixes PrefixCollection) erro
 	})
 	cLog.Info("prefixes updated, reloading")
 
+	// determine command and arguments to execute
+	commandArgs, err := buildCommand(config.ReloadCommand, config.ReloadArgs, config.ReloadShell)
+	if err != nil {
+		cLog.WithError(err).Warning("invalid reload command")
+		reloadOutcomeMetric.WithLabelValues(reloadOutcomeFailure).Inc()
+
+		return err
+	}
+
 	// issue reload command, with some reasonable timeout
 	ctx, cancel := context.WithTimeout(context.Background(), reloadTimeout)
 	defer cancel()
 
-	// split reload command into command/args assuming the first part is the command
-	// and the rest are the arguments
-	commandArgs := strings.Split(config.ReloadCommand, " ")
-
 	// set up command execution within that context
-	cmd := exec.CommandContext(ctx, commandArgs[0], commandArgs[1:]...)
+	cmd := commandContext(ctx, commandArgs)
 
 	// get exit code of command
+	beginReload := time.Now()
 	output, err := cmd.Output()
+	reloadDurationMetric.Observe(time.Since(beginReload).Seconds())
 
 	// We want to check the context error to see if the timeout was executed.
 	// The error returned by cmd.Output() will be OS specific based on what
 	// happens when a process is killed.
 	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
 		cLog.WithField("timeout", reloadTimeout).Warning("reloading timed out")
+		reloadOutcomeMetric.WithLabelValues(reloadOutcomeTimeout).Inc()
 
 		return ctx.Err()
 	}
 
 	if err != nil {
 		cLog.WithError(err).WithField("output", output).Warning("reloading failed")
+		reloadOutcomeMetric.WithLabelValues(reloadOutcomeFailure).Inc()
 	} else {
 		cLog.Debug("reloading succeeded")
+		reloadOutcomeMetric.WithLabelValues(reloadOutcomeSuccess).Inc()
+		reloadLastSuccessMetric.SetToCurrentTime()
+		updateAnnouncedMetric(prefixes)
+
+		if config.Backend == BackendFRR {
+			if err := markFRRApplied(config); err != nil {
+				cLog.WithError(err).Warning("could not record applied config")
+			}
+		}
 
 		// mark successful reload
 		h.reloadedBefore = true
@@ -215,14 +524,27 @@ func (h *HealthCheck) applyConfig(config Config, prefixes PrefixCollection) erro
 	return err
 }
 
-func (h *HealthCheck) addPrefix(svc *ServiceCheck, prefix net.IPNet) {
+// updateAnnouncedMetric sets the number of announced prefixes per function name
+func updateAnnouncedMetric(prefixes PrefixCollection) {
+	for name, ps := range prefixes {
+		prefixAnnouncedMetric.WithLabelValues(name).Set(float64(len(ps.Prefixes())))
+	}
+}
+
+func (h *HealthCheck) addPrefix(svc *ServiceCheck, prefix Prefix) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
 	h.ensurePrefixSet(svc.FunctionName)
 
 	h.prefixes[svc.FunctionName].Add(prefix)
 	prefixStateMetric.WithLabelValues(svc.Name(), prefix.String()).Set(1.0)
 }
 
-func (h *HealthCheck) removePrefix(svc *ServiceCheck, prefix net.IPNet) {
+func (h *HealthCheck) removePrefix(svc *ServiceCheck, prefix Prefix) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
 	h.ensurePrefixSet(svc.FunctionName)
 
 	h.prefixes[svc.FunctionName].Remove(prefix)
@@ -241,10 +563,89 @@ func (h *HealthCheck) ensurePrefixSet(functionName string) {
 	}
 }
 
+// ServiceStatuses returns the status of all services, sorted by name
+func (h *HealthCheck) ServiceStatuses() []ServiceStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	statuses := make([]ServiceStatus, len(h.services))
+	for i, s := range h.services {
+		statuses[i] = s.Status()
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+// NodeState returns the states of the services of this node, as fetched by
+// its peers
+func (h *HealthCheck) NodeState() NodeState {
+	statuses := h.ServiceStatuses()
+
+	state := NodeState{
+		Node:      h.Config.Peering.Node,
+		Services:  make(map[string]ServiceState, len(statuses)),
+		Withdrawn: []string{},
+	}
+
+	for _, status := range statuses {
+		state.Services[status.Name] = status.State
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	// prefixes of services that are down may still be announced, either
+	// because they are held or because the transition is not handled yet
+	for _, s := range h.services {
+		if s.IsUp() || slices.ContainsFunc(s.prefixes, func(p Prefix) bool {
+			return h.isAnnounced(s.FunctionName, p)
+		}) {
+			continue
+		}
+
+		state.Withdrawn = append(state.Withdrawn, s.name)
+	}
+
+	sort.Strings(state.Withdrawn)
+
+	return state
+}
+
+// Peers returns the last known state of the peers of this node
+func (h *HealthCheck) Peers() []PeerStatus {
+	return h.peering.Peers()
+}
+
+// AnnouncedPrefixes returns the currently announced prefixes per function name
+func (h *HealthCheck) AnnouncedPrefixes() map[string][]string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	announced := make(map[string][]string, len(h.prefixes))
+	for name, ps := range h.prefixes {
+		prefixes := ps.Prefixes()
+
+		announced[name] = make([]string, len(prefixes))
+		for i, p := range prefixes {
+			announced[name][i] = p.String()
+		}
+	}
+
+	return announced
+}
+
 // Stop signals all servic checks to stop as well and then stops itself
 func (h *HealthCheck) Stop() {
 	// signal each service to stop
 	for _, s := range h.services {
+		if s.isComposite() {
+			continue
+		}
+
 		log.WithFields(log.Fields{
 			"service": s.Name(),
 		}).Info("stopping service check")
@@ -252,5 +653,11 @@ func (h *HealthCheck) Stop() {
 		s.Stop()
 	}
 
+	if h.notifier != nil {
+		h.notifier.Stop()
+	}
+
+	h.peering.Stop()
+
 	h.stopped <- true
 }
@@ -1,11 +1,13 @@
 package birdwatcher
 
 import (
-	"net"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHealthCheck_addPrefix(t *testing.T) {
@@ -16,17 +18,17 @@ func TestHealthCheck_addPrefix(t *testing.T) {
 
 	// adding a prefix should initialise the prefixcollection
 	// and add the prefix under the right prefixset
-	_, prefix, _ := net.ParseCIDR("1.2.3.0/24")
-	hc.addPrefix(&ServiceCheck{name: "svc1", FunctionName: "foo"}, *prefix)
+	_, prefix, _ := parsePrefix("1.2.3.0/24")
+	hc.addPrefix(&ServiceCheck{name: "svc1", FunctionName: "foo"}, prefix)
 	assert.Len(t, hc.prefixes, 1)
-	assert.Equal(t, *prefix, hc.prefixes["foo"].prefixes[0])
+	assert.Equal(t, prefix, hc.prefixes["foo"].prefixes[0])
 
 	assert.InEpsilon(t, 1.0, testutil.ToFloat64(prefixStateMetric.WithLabelValues("svc1", "1.2.3.0/24")), 0.00001)
 
-	_, prefix, _ = net.ParseCIDR("2.3.4.0/24")
-	hc.addPrefix(&ServiceCheck{name: "svc2", FunctionName: "bar"}, *prefix)
+	_, prefix, _ = parsePrefix("2.3.4.0/24")
+	hc.addPrefix(&ServiceCheck{name: "svc2", FunctionName: "bar"}, prefix)
 	assert.Len(t, hc.prefixes, 2)
-	assert.Equal(t, *prefix, hc.prefixes["bar"].prefixes[0])
+	assert.Equal(t, prefix, hc.prefixes["bar"].prefixes[0])
 
 	assert.InEpsilon(t, 1.0, testutil.ToFloat64(prefixStateMetric.WithLabelValues("svc2", "2.3.4.0/24")), 0.00001)
 }
@@ -37,10 +39,10 @@ func TestHealthCheck_removePrefix(t *testing.T) {
 	hc := HealthCheck{}
 	assert.Nil(t, hc.prefixes)
 
-	_, prefix, _ := net.ParseCIDR("1.2.3.0/24")
+	_, prefix, _ := parsePrefix("1.2.3.0/24")
 
 	svc1 := &ServiceCheck{name: "svc1", FunctionName: "foo"}
-	hc.addPrefix(svc1, *prefix)
+	hc.addPrefix(svc1, prefix)
 	assert.Len(t, hc.prefixes, 1)
 	assert.Len(t, hc.prefixes["foo"].prefixes, 1)
 
@@ -48,7 +50,7 @@ func TestHealthCheck_removePrefix(t *testing.T) {
 
 	// this should initialise the prefixset but won't remove any prefixes
 	svc2 := &ServiceCheck{name: "svc2", FunctionName: "bar"}
-	hc.removePrefix(svc2, *prefix)
+	hc.removePrefix(svc2, prefix)
 	assert.Len(t, hc.prefixes, 2)
 	assert.Len(t, hc.prefixes["foo"].prefixes, 1)
 	assert.Empty(t, hc.prefixes["bar"].prefixes)
@@ -56,7 +58,7 @@ func TestHealthCheck_removePrefix(t *testing.T) {
 	assert.Empty(t, testutil.ToFloat64(prefixStateMetric.WithLabelValues("svc2", "1.2.3.0/24")))
 
 	// remove the prefix from the right prefixset
-	hc.removePrefix(svc1, *prefix)
+	hc.removePrefix(svc1, prefix)
 	assert.Empty(t, hc.prefixes["foo"].prefixes)
 
 	assert.Empty(t, testutil.ToFloat64(prefixStateMetric.WithLabelValues("svc1", "1.2.3.0/24")))
@@ -90,14 +92,14 @@ func TestHealthCheck_handleAction(t *testing.T) {
 	// create action with state up and 2 prefixes
 	action := &Action{
 		State:    ServiceStateUp,
-		Prefixes: make([]net.IPNet, 2),
+		Prefixes: make([]Prefix, 2),
 	}
 
-	var prefix *net.IPNet
-	_, prefix, _ = net.ParseCIDR("1.2.3.0/24")
-	action.Prefixes[0] = *prefix
-	_, prefix, _ = net.ParseCIDR("2.3.4.0/24")
-	action.Prefixes[1] = *prefix
+	var prefix Prefix
+	_, prefix, _ = parsePrefix("1.2.3.0/24")
+	action.Prefixes[0] = prefix
+	_, prefix, _ = parsePrefix("2.3.4.0/24")
+	action.Prefixes[1] = prefix
 	action.Service = &ServiceCheck{
 		FunctionName: "test",
 	}
@@ -129,6 +131,53 @@ func TestHealthCheck_handleAction(t *testing.T) {
 	}
 }
 
+func TestHealthCheck_handleActionComposite(t *testing.T) {
+	t.Parallel()
+
+	_, prefixA, _ := parsePrefix("1.2.3.0/24")
+	_, prefixC, _ := parsePrefix("2.3.4.0/24")
+	_, prefixD, _ := parsePrefix("3.4.5.0/24")
+
+	a := &ServiceCheck{name: "a", FunctionName: "test", prefixes: []Prefix{prefixA}}
+	b := &ServiceCheck{name: "b", FunctionName: "test"}
+	c := &ServiceCheck{name: "c", FunctionName: "test", Depends: "a && !b", prefixes: []Prefix{prefixC}}
+	c.depends, _ = parseDependencies(c.Depends)
+	d := &ServiceCheck{name: "d", FunctionName: "other", Depends: "c", prefixes: []Prefix{prefixD}}
+	d.depends, _ = parseDependencies(d.Depends)
+
+	hc := HealthCheck{services: []*ServiceCheck{a, b, c, d}}
+
+	sc := make(chan string)
+	go func() {
+		// make sure to read the status channel to prevent blocking handleAction
+		for range sc {
+		}
+	}()
+	defer close(sc)
+
+	// a coming up brings up c and through c also d
+	a.setState(ServiceStateUp)
+	hc.handleAction(a.getAction(), sc)
+
+	assert.True(t, c.IsUp())
+	assert.True(t, d.IsUp())
+	assert.Equal(t, []Prefix{prefixA, prefixC}, hc.prefixes["test"].prefixes)
+	assert.Equal(t, []Prefix{prefixD}, hc.prefixes["other"].prefixes)
+
+	// b coming up brings down c and d
+	b.setState(ServiceStateUp)
+	hc.handleAction(b.getAction(), sc)
+
+	assert.False(t, c.IsUp())
+	assert.False(t, d.IsUp())
+	assert.Equal(t, []Prefix{prefixA}, hc.prefixes["test"].prefixes)
+	assert.Empty(t, hc.prefixes["other"].prefixes)
+
+	// transitions of services no composite service depends on don't affect
+	// other services
+	assert.Empty(t, hc.withDependents(d.getAction())[1:])
+}
+
 func TestHealthCheck_statusUpdate(t *testing.T) {
 	t.Parallel()
 
@@ -143,3 +192,109 @@ func TestHealthCheck_statusUpdate(t *testing.T) {
 	hc.services[1].state = ServiceStateUp
 	assert.Equal(t, "all 2 service(s) up", hc.statusUpdate())
 }
+
+func TestHealthCheck_applyConfig(t *testing.T) {
+	t.Parallel()
+
+	hc := NewHealthCheck(Config{})
+	config := Config{
+		ConfigFile:    filepath.Join(t.TempDir(), "birdwatcher.conf"),
+		ReloadCommand: "/usr/bin/true",
+	}
+
+	prefixes := make(PrefixCollection)
+	prefixes["apply_config"] = NewPrefixSet("apply_config")
+
+	_, prefix, _ := parsePrefix("1.2.3.0/24")
+	prefixes["apply_config"].Add(prefix)
+
+	successes := testutil.ToFloat64(reloadOutcomeMetric.WithLabelValues(reloadOutcomeSuccess))
+	skipped := testutil.ToFloat64(reloadSkippedMetric)
+
+	// first apply should write the file and reload
+	require.NoError(t, hc.applyConfig(config, prefixes))
+	assert.True(t, hc.didReloadBefore())
+	assert.InEpsilon(t, successes+1, testutil.ToFloat64(reloadOutcomeMetric.WithLabelValues(reloadOutcomeSuccess)), 0.00001)
+	assert.Positive(t, testutil.ToFloat64(reloadLastSuccessMetric))
+	assert.InEpsilon(t, 1.0, testutil.ToFloat64(prefixAnnouncedMetric.WithLabelValues("apply_config")), 0.00001)
+
+	// applying the same prefixes again should skip reloading
+	require.NoError(t, hc.applyConfig(config, prefixes))
+	assert.InEpsilon(t, skipped+1, testutil.ToFloat64(reloadSkippedMetric), 0.00001)
+
+	// failing reload command should return an error
+	prefixes["apply_config"].Remove(prefix)
+
+	config.ReloadCommand = "/usr/bin/false"
+	require.Error(t, hc.applyConfig(config, prefixes))
+}
+
+func TestHealthCheck_CheckProgress(t *testing.T) {
+	t.Parallel()
+
+	svc := &ServiceCheck{name: "foo", Interval: time.Second, Timeout: time.Second}
+	hc := HealthCheck{services: []*ServiceCheck{svc}}
+
+	// nothing made progress yet
+	assert.Error(t, hc.CheckProgress())
+
+	hc.markProgress()
+	svc.markProgress()
+	assert.NoError(t, hc.CheckProgress())
+
+	// service stalled longer than its interval, timeout and grace period
+	svc.lastProgress.Store(time.Now().Add(-svc.maxLoopDuration() - time.Second).UnixNano())
+	err := hc.CheckProgress()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "service foo made no progress")
+	}
+
+	// action loop stalled
+	svc.markProgress()
+	hc.lastProgress.Store(time.Now().Add(-time.Hour).UnixNano())
+	err = hc.CheckProgress()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "action loop made no progress")
+	}
+}
+
+func TestHealthCheck_supervise(t *testing.T) {
+	t.Parallel()
+
+	_, prefix, _ := parsePrefix("3.4.5.0/24")
+	svc := &ServiceCheck{
+		name:         "supervised",
+		FunctionName: "supervise",
+		Interval:     time.Second,
+		Timeout:      time.Second,
+		prefixes:     []Prefix{prefix},
+		state:        ServiceStateUp,
+	}
+
+	hc := HealthCheck{
+		Config:   Config{StallTimeout: 10 * time.Second},
+		services: []*ServiceCheck{svc},
+	}
+	hc.addPrefix(svc, prefix)
+
+	sc := make(chan string, 1)
+
+	// service making progress should be left alone
+	svc.markProgress()
+	hc.supervise(sc)
+	assert.True(t, svc.IsUp())
+	assert.Len(t, hc.prefixes["supervise"].prefixes, 1)
+	assert.Less(t, testutil.ToFloat64(serviceStalenessMetric.WithLabelValues("supervised")), 1.0)
+
+	// stalled service should be forced down
+	svc.lastProgress.Store(time.Now().Add(-time.Minute).UnixNano())
+	hc.supervise(sc)
+	assert.False(t, svc.IsUp())
+	assert.Equal(t, ServiceStateUp, svc.previousState)
+	assert.Empty(t, hc.prefixes["supervise"].prefixes)
+	assert.GreaterOrEqual(t, testutil.ToFloat64(serviceStalenessMetric.WithLabelValues("supervised")), 60.0)
+	<-sc
+
+	// service that is down already can't be forced down again
+	assert.Nil(t, svc.forceDown())
+}
@@ -0,0 +1,200 @@
+package birdwatcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// readIncludes merges the services of the files matching the include patterns
+// of given config. Relative patterns are relative to the directory of the
+// config file.
+func readIncludes(conf *Config, configFile string) error {
+	seen := map[string]bool{filepath.Clean(configFile): true}
+
+	for _, pattern := range conf.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(configFile), pattern)
+		}
+
+		// matches are sorted, so files are read in a fixed order
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid include %s: %w", pattern, err)
+		}
+
+		for _, filename := range matches {
+			if seen[filepath.Clean(filename)] {
+				continue
+			}
+
+			seen[filepath.Clean(filename)] = true
+
+			if err := readInclude(conf, filename); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readInclude merges the services of given file into given config
+func readInclude(conf *Config, filename string) error {
+	var include struct {
+		Services map[string]*ServiceCheck
+	}
+
+	md, err := decodeFile(filename, &include)
+	if err != nil {
+		return fmt.Errorf("could not parse included file %s: %s", filename, parseErrorMessage(err))
+	}
+
+	for _, key := range md.Keys() {
+		if key[0] != "services" {
+			return fmt.Errorf("included file %s can only contain services, found %s", filename, key[0])
+		}
+	}
+
+	for name, s := range include.Services {
+		convertIntervals(md, s, "services", name)
+	}
+
+	if err := applyTemplates(md, conf, include.Services); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(include.Services))
+	for name := range include.Services {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if conf.Services == nil {
+		conf.Services = make(map[string]*ServiceCheck, len(names))
+	}
+
+	for _, name := range names {
+		s := include.Services[name]
+		s.name = name
+		s.source = filename
+
+		if existing, found := conf.Services[name]; found {
+			return fmt.Errorf("service %s at %s is already defined at %s", name, s.definedAt(), existing.definedAt())
+		}
+
+		conf.Services[name] = s
+	}
+
+	conf.files = append(conf.files, filename)
+
+	return nil
+}
+
+// Files returns the configuration files that were read, starting with the
+// main configuration file followed by the included files
+func (c Config) Files() []string {
+	return c.files
+}
+
+// definedAt returns the file and line the service is defined at
+func (s *ServiceCheck) definedAt() string {
+	return fileLine(s.source, findLine(s.source, 0, s.tableHeaders()...))
+}
+
+// prefixDefinedAt returns the file and line given prefix of the service is
+// defined at, either in the configuration or in its prefix file
+func (s *ServiceCheck) prefixDefinedAt(prefix string) string {
+	if line := s.findInTable(strconv.Quote(prefix), "'"+prefix+"'"); line > 0 {
+		return fileLine(s.source, line)
+	}
+
+	if s.PrefixFile != "" {
+		if line := findLine(s.PrefixFile, 0, prefix); line > 0 {
+			return fileLine(s.PrefixFile, line)
+		}
+	}
+
+	return s.definedAt()
+}
+
+// findInTable returns the number of the first line in the table of the
+// service containing any of given strings, or 0 if none of them is found
+func (s *ServiceCheck) findInTable(needles ...string) int {
+	header := findLine(s.source, 0, s.tableHeaders()...)
+	if header == 0 {
+		return 0
+	}
+
+	line := findLine(s.source, header, needles...)
+	if line == 0 {
+		return 0
+	}
+
+	// the line should come before the next table
+	if next := findTable(s.source, header); next > 0 && next < line {
+		return 0
+	}
+
+	return line
+}
+
+// tableHeaders returns the ways the table of the service can be written
+func (s *ServiceCheck) tableHeaders() []string {
+	return []string{
+		"[services." + s.name + "]",
+		"[services." + strconv.Quote(s.name) + "]",
+		"[services.'" + s.name + "']",
+	}
+}
+
+// findLine returns the number of the first line after given line in given
+// file containing any of given strings, or 0 if none of them is found
+func findLine(filename string, after int, needles ...string) int {
+	lines := readLines(filename)
+	for i := after; i < len(lines); i++ {
+		for _, needle := range needles {
+			if strings.Contains(lines[i], needle) {
+				return i + 1
+			}
+		}
+	}
+
+	return 0
+}
+
+// findTable returns the number of the first line after given line in given
+// file starting a table, or 0 if there is none
+func findTable(filename string, after int) int {
+	lines := readLines(filename)
+	for i := after; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "[") {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// readLines returns the lines of given file, none if it can't be read
+func readLines(filename string) []string {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+
+	return strings.Split(string(data), "\n")
+}
+
+// fileLine formats given file and line, leaving out unknown lines
+func fileLine(filename string, line int) string {
+	if line == 0 {
+		return filename
+	}
+
+	return filename + ":" + strconv.Itoa(line)
+}
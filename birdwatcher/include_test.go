@@ -0,0 +1,62 @@
+package birdwatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadIncludes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("services merged", func(t *testing.T) {
+		t.Parallel()
+
+		testConf := Config{}
+
+		err := ReadConfig(&testConf, "testdata/config/include")
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"testdata/config/include",
+			"testdata/config/include.d/a.toml",
+			"testdata/config/include.d/b.toml",
+		}, testConf.Files())
+
+		if assert.Len(t, testConf.Services, 3) {
+			assert.Equal(t, "testdata/config/include", testConf.Services["main"].source)
+			assert.Equal(t, "testdata/config/include.d/a.toml", testConf.Services["foo"].source)
+			assert.Equal(t, 5*time.Second, testConf.Services["foo"].Interval)
+			assert.Equal(t, 500*time.Millisecond, testConf.Services["bar"].Interval)
+		}
+	})
+
+	t.Run("duplicate service name", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/include_duplicatename")
+		if assert.Error(t, err) {
+			assert.Equal(t, "service foo at testdata/config/include.d/a.toml:1 is already defined at testdata/config/include_duplicatename:4", err.Error())
+		}
+	})
+
+	t.Run("duplicate prefix", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/include_duplicateprefix")
+		if assert.Error(t, err) {
+			assert.Equal(t, "duplicate prefix 192.168.3.0/24 found in service bar at testdata/config/include.d/b.toml:7 and service main at testdata/config/include_duplicateprefix:6", err.Error())
+		}
+	})
+
+	t.Run("not only services", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/include_invalid")
+		if assert.Error(t, err) {
+			assert.Equal(t, "included file testdata/config/include_invalid.d/a.toml can only contain services, found backend", err.Error())
+		}
+	})
+}
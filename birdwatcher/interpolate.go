@@ -0,0 +1,209 @@
+package birdwatcher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// escapes values interpolated into basic strings
+var basicStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// decodeFile decodes given TOML file into v, after interpolating references
+// to environment variables in it
+func decodeFile(filename string, v any) (toml.MetaData, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return toml.MetaData{}, err
+	}
+
+	doc, err := interpolateTOML(string(data))
+	if err != nil {
+		return toml.MetaData{}, fmt.Errorf("could not interpolate %w", err)
+	}
+
+	return toml.Decode(doc, v)
+}
+
+// interpolateTOML replaces references to environment variables in given TOML
+// document, either ${VAR} or ${VAR:-default} to fall back to a default when
+// the variable is unset or empty. $${ is kept as ${. This happens before
+// decoding, so any setting can refer to them: within strings the values are
+// escaped, outside of strings they are used as is, for instance for numbers.
+// References in comments are left alone.
+func interpolateTOML(doc string) (string, error) {
+	var (
+		b strings.Builder
+		// delimiter of the string being scanned, if any
+		quote string
+		line  = 1
+	)
+
+	for i := 0; i < len(doc); {
+		rest := doc[i:]
+
+		if quote == "" {
+			if rest[0] == '#' {
+				end := lineEnd(rest)
+				b.WriteString(rest[:end])
+				i += end
+
+				continue
+			}
+
+			if quote = openingQuote(rest); quote != "" {
+				b.WriteString(quote)
+				i += len(quote)
+
+				continue
+			}
+		} else {
+			// escaped characters in basic strings, such as \"
+			if quote[0] == '"' && rest[0] == '\\' && len(rest) > 1 {
+				if rest[1] == '\n' {
+					line++
+				}
+
+				b.WriteString(rest[:2])
+				i += 2
+
+				continue
+			}
+
+			if strings.HasPrefix(rest, quote) {
+				b.WriteString(quote)
+				i += len(quote)
+				quote = ""
+
+				continue
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(rest, "$${"):
+			b.WriteString("${")
+			i += 3
+		case strings.HasPrefix(rest, "${"):
+			value, n, err := expandReference(rest[:lineEnd(rest)])
+			if err != nil {
+				return "", fmt.Errorf("line %d: %w", line, err)
+			}
+
+			if value, err = escapeValue(value, quote); err != nil {
+				return "", fmt.Errorf("line %d: %w", line, err)
+			}
+
+			b.WriteString(value)
+			i += n
+		default:
+			if rest[0] == '\n' {
+				line++
+			}
+
+			b.WriteByte(rest[0])
+			i++
+		}
+	}
+
+	return b.String(), nil
+}
+
+// openingQuote returns the delimiter of the string given text starts with, if
+// any
+func openingQuote(s string) string {
+	for _, quote := range []string{`"""`, `'''`, `"`, `'`} {
+		if strings.HasPrefix(s, quote) {
+			return quote
+		}
+	}
+
+	return ""
+}
+
+// escapeValue escapes given value for use within a string with given
+// delimiter, or returns it as is outside of strings
+func escapeValue(value, quote string) (string, error) {
+	switch quote {
+	case "":
+		// a value such as a number or boolean can't add keys or tables
+		if !isScalarValue(value) {
+			return "", errors.New("value can only be a number, boolean or date outside of strings, use a basic string instead")
+		}
+
+		return value, nil
+	case `'`, `'''`:
+		// literal strings have no escapes
+		if strings.Contains(value, "'") || (quote == `'` && strings.ContainsAny(value, "\r\n")) {
+			return "", errors.New("value can not be used in a literal string, use a basic string instead")
+		}
+
+		return value, nil
+	default:
+		return basicStringEscaper.Replace(value), nil
+	}
+}
+
+// lineEnd returns the length of the first line of given text
+func lineEnd(s string) int {
+	if end := strings.IndexByte(s, '\n'); end >= 0 {
+		return end
+	}
+
+	return len(s)
+}
+
+// expandReference returns the value of the reference to an environment
+// variable given text starts with, along with the length of the reference
+func expandReference(s string) (string, int, error) {
+	end := strings.IndexByte(s, '}')
+	if end < 0 {
+		return "", 0, fmt.Errorf("missing } in %q", s)
+	}
+
+	name, fallback, hasFallback := strings.Cut(s[2:end], ":-")
+	if !isVariableName(name) {
+		return "", 0, fmt.Errorf("invalid variable name %q", name)
+	}
+
+	value, found := os.LookupEnv(name)
+
+	switch {
+	case hasFallback && value == "":
+		value = fallback
+	case !found:
+		return "", 0, fmt.Errorf("variable %s is not set", name)
+	}
+
+	return value, end + 1, nil
+}
+
+// isVariableName returns whether given name is a valid name of an environment
+// variable
+func isVariableName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+
+	for _, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isScalarValue returns whether given value consists of the characters of
+// TOML numbers, booleans and dates only, so it can be used outside strings
+func isScalarValue(value string) bool {
+	for _, r := range value {
+		if !strings.ContainsRune("_-+.:", r) && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+
+	return true
+}
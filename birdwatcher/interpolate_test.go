@@ -0,0 +1,106 @@
+package birdwatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// these tests set environment variables, so they can't run in parallel
+//
+//nolint:paralleltest // uses t.Setenv
+func TestInterpolateTOML(t *testing.T) {
+	t.Setenv("BIRDWATCHER_TEST_FOO", "foo")
+	t.Setenv("BIRDWATCHER_TEST_EMPTY", "")
+	t.Setenv("BIRDWATCHER_TEST_PORT", "9091")
+	t.Setenv("BIRDWATCHER_TEST_QUOTE", `say "hi" \o/`)
+	t.Setenv("BIRDWATCHER_TEST_APOSTROPHE", "it's")
+	t.Setenv("BIRDWATCHER_TEST_INJECT", "9091\n[services.evil]\ncommand = \"/bin/true\"")
+	t.Setenv("BIRDWATCHER_TEST_DATE", "2024-01-01T12:00:00+01:00")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"plain", "plain"},
+		{"${BIRDWATCHER_TEST_FOO}", "foo"},
+		{"a ${BIRDWATCHER_TEST_FOO} b ${BIRDWATCHER_TEST_FOO}", "a foo b foo"},
+		{"${BIRDWATCHER_TEST_UNSET:-bar}", "bar"},
+		{"${BIRDWATCHER_TEST_FOO:-bar}", "foo"},
+		{"${BIRDWATCHER_TEST_EMPTY}", ""},
+		{"${BIRDWATCHER_TEST_EMPTY:-bar}", "bar"},
+		{"${BIRDWATCHER_TEST_UNSET:-}", ""},
+		{"$${BIRDWATCHER_TEST_FOO}", "${BIRDWATCHER_TEST_FOO}"},
+		{"$BIRDWATCHER_TEST_FOO $1", "$BIRDWATCHER_TEST_FOO $1"},
+		// values are escaped within basic strings
+		{`name = "${BIRDWATCHER_TEST_QUOTE}"`, `name = "say \"hi\" \\o/"`},
+		{`name = """${BIRDWATCHER_TEST_QUOTE}"""`, `name = """say \"hi\" \\o/"""`},
+		{`name = "\"${BIRDWATCHER_TEST_FOO}\""`, `name = "\"foo\""`},
+		{`name = '${BIRDWATCHER_TEST_FOO}'`, `name = 'foo'`},
+		// and used as is outside of strings
+		{"port = ${BIRDWATCHER_TEST_PORT:-1234}", "port = 9091"},
+		{"since = ${BIRDWATCHER_TEST_DATE}", "since = 2024-01-01T12:00:00+01:00"},
+		// comments are left alone
+		{"# uses ${BIRDWATCHER_TEST_UNSET}\nname = \"${BIRDWATCHER_TEST_FOO}\" # ${BIRDWATCHER_TEST_UNSET}", "# uses ${BIRDWATCHER_TEST_UNSET}\nname = \"foo\" # ${BIRDWATCHER_TEST_UNSET}"},
+		{`name = "# ${BIRDWATCHER_TEST_FOO}"`, `name = "# foo"`},
+	}
+
+	for _, test := range tests {
+		value, err := interpolateTOML(test.input)
+		require.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, value, test.input)
+	}
+
+	for input, expected := range map[string]string{
+		"${BIRDWATCHER_TEST_UNSET}":        "line 1: variable BIRDWATCHER_TEST_UNSET is not set",
+		"${BIRDWATCHER_TEST_FOO\nfoo = 1}": `line 1: missing } in "${BIRDWATCHER_TEST_FOO"`,
+		"${}":                              `line 1: invalid variable name ""`,
+		"\n\n${1FOO}":                      `line 3: invalid variable name "1FOO"`,
+		"${FOO BAR}":                       `line 1: invalid variable name "FOO BAR"`,
+		"name = '${BIRDWATCHER_TEST_APOSTROPHE}'":  "line 1: value can not be used in a literal string, use a basic string instead",
+		"port = ${BIRDWATCHER_TEST_INJECT}":        "line 1: value can only be a number, boolean or date outside of strings, use a basic string instead",
+		"name = ${BIRDWATCHER_TEST_APOSTROPHE}":    "line 1: value can only be a number, boolean or date outside of strings, use a basic string instead",
+		"name = '''\n${BIRDWATCHER_TEST_UNSET}'''": "line 2: variable BIRDWATCHER_TEST_UNSET is not set",
+	} {
+		_, err := interpolateTOML(input)
+		if assert.Error(t, err, input) {
+			assert.Equal(t, expected, err.Error())
+		}
+	}
+}
+
+//nolint:paralleltest // uses t.Setenv
+func TestInterpolateConfig(t *testing.T) {
+	t.Setenv("BIRDWATCHER_TEST_DIR", "/etc/bird")
+	t.Setenv("BIRDWATCHER_TEST_PREFIX", "192.168.0.0/24")
+	t.Setenv("BIRDWATCHER_TEST_PORT", "9091")
+	t.Setenv("BIRDWATCHER_TEST_INTERVAL", "5s")
+
+	t.Run("interpolated", func(t *testing.T) {
+		testConf := Config{}
+
+		err := ReadConfig(&testConf, "testdata/config/interpolate")
+		require.NoError(t, err)
+
+		assert.Equal(t, "/etc/bird/birdwatcher.conf", testConf.ConfigFile)
+		assert.True(t, testConf.Prometheus.Enabled)
+		assert.Equal(t, 9091, testConf.Prometheus.Port)
+
+		foo := testConf.Services["foo"]
+		assert.Equal(t, "test -S ${SOCKET}", foo.Command)
+		assert.Equal(t, "match_foo", foo.FunctionName)
+		assert.Equal(t, []string{"192.168.0.0/24"}, foo.Prefixes)
+		assert.Equal(t, map[string]string{"SITE": "ams"}, foo.Env)
+		assert.Equal(t, 5*time.Second, foo.Interval)
+		assert.Equal(t, 3, foo.Rise)
+	})
+
+	t.Run("unset variable", func(t *testing.T) {
+		err := ReadConfig(&Config{}, "testdata/config/interpolate_unset")
+		if assert.Error(t, err) {
+			assert.Equal(t, "could not parse config: could not interpolate line 4: variable BIRDWATCHER_TEST_UNSET is not set", err.Error())
+		}
+	})
+}
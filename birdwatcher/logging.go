@@ -0,0 +1,252 @@
+package birdwatcher
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-systemd/journal"
+	log "github.com/sirupsen/logrus"
+	lsyslog "github.com/sirupsen/logrus/hooks/syslog"
+)
+
+// LogConfig holds configuration related to logging
+type LogConfig struct {
+	Format string
+	Output string
+	File   string
+}
+
+const (
+	// LogFormatText logs in logrus' text format
+	LogFormatText = "text"
+	// LogFormatJSON logs every entry as JSON object
+	LogFormatJSON = "json"
+
+	// LogOutputStdout writes logs to stdout
+	LogOutputStdout = "stdout"
+	// LogOutputFile writes logs to the configured file
+	LogOutputFile = "file"
+	// LogOutputSyslog sends logs to the local syslog daemon
+	LogOutputSyslog = "syslog"
+	// LogOutputJournald sends logs natively to journald
+	LogOutputJournald = "journald"
+
+	// tag used for syslog messages
+	syslogTag = "birdwatcher"
+)
+
+// the log file currently in use, if any
+var logFile *reopenWriter
+
+// validateLogConfig checks given log configuration and applies defaults
+func validateLogConfig(c *LogConfig) error {
+	if c.Format == "" {
+		c.Format = LogFormatText
+	}
+
+	if c.Output == "" {
+		c.Output = LogOutputStdout
+	}
+
+	switch c.Format {
+	case LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %s", c.Format)
+	}
+
+	switch c.Output {
+	case LogOutputStdout, LogOutputSyslog, LogOutputJournald:
+	case LogOutputFile:
+		if c.File == "" {
+			return errors.New("log output file requires a log file to be set")
+		}
+	default:
+		return fmt.Errorf("unknown log output %s", c.Output)
+	}
+
+	return nil
+}
+
+// ConfigureLogging sets up the standard logger with given configuration. When
+// running under systemd, logs on stdout omit timestamps, since journald adds
+// those.
+func ConfigureLogging(c LogConfig, systemd bool) error {
+	if err := validateLogConfig(&c); err != nil {
+		return err
+	}
+
+	log.SetFormatter(logFormatter(c, systemd))
+
+	switch c.Output {
+	case LogOutputFile:
+		w, err := newReopenWriter(c.File)
+		if err != nil {
+			return err
+		}
+
+		logFile = w
+		log.SetOutput(w)
+	case LogOutputSyslog:
+		hook, err := lsyslog.NewSyslogHook("", "", syslog.LOG_DAEMON, syslogTag)
+		if err != nil {
+			return fmt.Errorf("could not connect to syslog: %w", err)
+		}
+
+		log.AddHook(hook)
+		log.SetOutput(io.Discard)
+	case LogOutputJournald:
+		if !journal.Enabled() {
+			return errors.New("journald is not available")
+		}
+
+		log.AddHook(journalHook{})
+		log.SetOutput(io.Discard)
+	default:
+		log.SetOutput(os.Stdout)
+	}
+
+	return nil
+}
+
+// logFormatter returns the formatter for given configuration, omitting
+// timestamps only when journald captures stdout
+func logFormatter(c LogConfig, systemd bool) log.Formatter {
+	disableTimestamp := systemd && c.Output == LogOutputStdout
+
+	if c.Format == LogFormatJSON {
+		return &log.JSONFormatter{DisableTimestamp: disableTimestamp}
+	}
+
+	return &log.TextFormatter{DisableTimestamp: disableTimestamp}
+}
+
+// ReopenLogFile reopens the log file, if logging to a file, so log files can be
+// rotated
+func ReopenLogFile() error {
+	if logFile == nil {
+		return nil
+	}
+
+	return logFile.Reopen()
+}
+
+// newServiceLogger returns a logger with given level, sharing its output,
+// formatter and hooks with the standard logger
+func newServiceLogger(level log.Level) *log.Logger {
+	std := log.StandardLogger()
+
+	l := log.New()
+	l.SetOutput(std.Out)
+	l.SetFormatter(std.Formatter)
+	l.ReplaceHooks(std.Hooks)
+	l.SetLevel(level)
+
+	return l
+}
+
+// reopenWriter is an io.Writer writing to a file that can be reopened
+type reopenWriter struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func newReopenWriter(path string) (*reopenWriter, error) {
+	w := &reopenWriter{path: path}
+	if err := w.Reopen(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Write writes to the currently opened file
+func (w *reopenWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Write(p)
+}
+
+// Reopen opens the file again and closes the previously opened file
+func (w *reopenWriter) Reopen() error {
+	//nolint:gosec // path comes from configuration
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != nil {
+		//nolint:errcheck // nothing to do about it anyway
+		w.file.Close()
+	}
+
+	w.file = f
+
+	return nil
+}
+
+// journalHook sends log entries natively to journald, with the entry's fields
+// as journal fields
+type journalHook struct{}
+
+// Levels returns the levels this hook fires for
+func (journalHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire sends the entry to journald
+func (journalHook) Fire(entry *log.Entry) error {
+	vars := make(map[string]string, len(entry.Data))
+	for k, v := range entry.Data {
+		vars[journalField(k)] = fmt.Sprint(v)
+	}
+
+	return journal.Send(entry.Message, journalPriority(entry.Level), vars)
+}
+
+// journalField converts a log field name into a valid journal field name,
+// which only consists of uppercase letters, digits and underscores
+func journalField(name string) string {
+	field := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+
+	// fields can not start with an underscore or digit
+	if field == "" || field[0] == '_' || (field[0] >= '0' && field[0] <= '9') {
+		field = "F" + field
+	}
+
+	return field
+}
+
+// journalPriority maps a log level on a journal priority
+func journalPriority(level log.Level) journal.Priority {
+	switch level {
+	case log.PanicLevel, log.FatalLevel:
+		return journal.PriCrit
+	case log.ErrorLevel:
+		return journal.PriErr
+	case log.WarnLevel:
+		return journal.PriWarning
+	case log.InfoLevel:
+		return journal.PriInfo
+	default:
+		return journal.PriDebug
+	}
+}
@@ -0,0 +1,95 @@
+package birdwatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLogConfig(t *testing.T) {
+	t.Parallel()
+
+	// defaults should be applied
+	c := LogConfig{}
+	require.NoError(t, validateLogConfig(&c))
+	assert.Equal(t, LogFormatText, c.Format)
+	assert.Equal(t, LogOutputStdout, c.Output)
+
+	err := validateLogConfig(&LogConfig{Format: "xml"})
+	if assert.Error(t, err) {
+		assert.Equal(t, "unknown log format xml", err.Error())
+	}
+
+	err = validateLogConfig(&LogConfig{Output: "printer"})
+	if assert.Error(t, err) {
+		assert.Equal(t, "unknown log output printer", err.Error())
+	}
+
+	err = validateLogConfig(&LogConfig{Output: LogOutputFile})
+	if assert.Error(t, err) {
+		assert.Equal(t, "log output file requires a log file to be set", err.Error())
+	}
+}
+
+func TestLogFormatter(t *testing.T) {
+	t.Parallel()
+
+	// journald timestamps stdout under systemd
+	assert.Equal(t, &log.TextFormatter{DisableTimestamp: true}, logFormatter(LogConfig{Format: LogFormatText, Output: LogOutputStdout}, true))
+	assert.Equal(t, &log.JSONFormatter{DisableTimestamp: true}, logFormatter(LogConfig{Format: LogFormatJSON, Output: LogOutputStdout}, true))
+
+	// other outputs keep their timestamps
+	assert.Equal(t, &log.TextFormatter{}, logFormatter(LogConfig{Format: LogFormatText, Output: LogOutputStdout}, false))
+	assert.Equal(t, &log.TextFormatter{}, logFormatter(LogConfig{Format: LogFormatText, Output: LogOutputFile}, true))
+	assert.Equal(t, &log.JSONFormatter{}, logFormatter(LogConfig{Format: LogFormatJSON, Output: LogOutputFile}, true))
+	assert.Equal(t, &log.JSONFormatter{}, logFormatter(LogConfig{Format: LogFormatJSON, Output: LogOutputSyslog}, true))
+}
+
+func TestReopenWriter(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "birdwatcher.log")
+
+	w, err := newReopenWriter(path)
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte("foo\n"))
+	require.NoError(t, err)
+
+	// simulate logrotate moving the file away
+	require.NoError(t, os.Rename(path, path+".1"))
+	require.NoError(t, w.Reopen())
+
+	_, err = w.Write([]byte("bar\n"))
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "foo\n", string(data))
+
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "bar\n", string(data))
+}
+
+func TestNewServiceLogger(t *testing.T) {
+	t.Parallel()
+
+	l := newServiceLogger(log.TraceLevel)
+	assert.Equal(t, log.TraceLevel, l.GetLevel())
+	assert.Equal(t, log.StandardLogger().Out, l.Out)
+}
+
+func TestJournalField(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "SERVICE", journalField("service"))
+	assert.Equal(t, "EXIT_CODE", journalField("exit_code"))
+	assert.Equal(t, "FOO_BAR", journalField("foo-bar"))
+	assert.Equal(t, "F_FOO", journalField("_foo"))
+	assert.Equal(t, "F1FOO", journalField("1foo"))
+}
@@ -0,0 +1,218 @@
+package birdwatcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// size of the queue of events waiting to be delivered
+	notifierQueueSize = 64
+	// delay between delivery attempts, multiplied by the attempt number
+	webhookRetryDelay = time.Second
+	// amount of bytes of check output included in events
+	eventOutputSize = 512
+	// header holding the HMAC signature of the payload
+	webhookSignatureHeader = "X-Birdwatcher-Signature"
+)
+
+// EventType represents the kind of event sent to webhooks
+type EventType string
+
+const (
+	// EventTypeTransition is sent when a service changes state
+	EventTypeTransition EventType = "transition"
+	// EventTypeReloadFailure is sent when the configuration could not be applied
+	EventTypeReloadFailure EventType = "reload_failure"
+)
+
+// Event is the JSON payload sent to webhooks
+type Event struct {
+	Type      EventType    `json:"type"`
+	Service   string       `json:"service,omitempty"`
+	OldState  ServiceState `json:"old_state,omitempty"`
+	NewState  ServiceState `json:"new_state,omitempty"`
+	Prefixes  []string     `json:"prefixes,omitempty"`
+	Output    string       `json:"output,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Hostname  string       `json:"hostname"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// Notifier delivers events to the configured webhooks in the background
+type Notifier struct {
+	webhooks   []WebhookConfig
+	hostname   string
+	retryDelay time.Duration
+	events     chan Event
+	stopped    chan any
+}
+
+// NewNotifier returns a Notifier for given webhooks
+func NewNotifier(webhooks []WebhookConfig) *Notifier {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.WithError(err).Warning("could not determine hostname for notifications")
+	}
+
+	return &Notifier{
+		webhooks:   webhooks,
+		hostname:   hostname,
+		retryDelay: webhookRetryDelay,
+		events:     make(chan Event, notifierQueueSize),
+		stopped:    make(chan any),
+	}
+}
+
+// Start delivers queued events until the Notifier is stopped
+func (n *Notifier) Start() {
+	for {
+		select {
+		case <-n.stopped:
+			return
+		case event := <-n.events:
+			for _, wh := range n.webhooks {
+				n.deliver(wh, event)
+			}
+		}
+	}
+}
+
+// Stop stops delivering events
+func (n *Notifier) Stop() {
+	close(n.stopped)
+}
+
+// Notify queues given event for delivery without blocking the caller
+func (n *Notifier) Notify(event Event) {
+	if n == nil || len(n.webhooks) == 0 {
+		return
+	}
+
+	event.Hostname = n.hostname
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	select {
+	case n.events <- event:
+	default:
+		log.WithField("type", event.Type).Warning("notification queue full, dropping event")
+	}
+}
+
+// deliver sends the event to given webhook, retrying on failure
+func (n *Notifier) deliver(wh WebhookConfig, event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.WithError(err).Error("could not encode event")
+
+		return
+	}
+
+	wLog := log.WithFields(log.Fields{
+		"url":  wh.URL,
+		"type": event.Type,
+	})
+
+	for attempt := 0; attempt <= wh.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-n.stopped:
+				return
+			case <-time.After(n.retryDelay * time.Duration(attempt)):
+			}
+		}
+
+		err = postWebhook(wh, payload)
+		if err == nil {
+			wLog.Debug("delivered event to webhook")
+
+			return
+		}
+
+		wLog.WithError(err).WithField("attempt", attempt+1).Warning("could not deliver event to webhook")
+	}
+
+	wLog.Error("giving up delivering event to webhook")
+}
+
+// postWebhook posts the payload to the webhook once
+func postWebhook(wh WebhookConfig, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), wh.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if wh.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signPayload(wh.Secret, payload))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // nothing to do about it anyway
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// signPayload returns the hex encoded HMAC-SHA256 of payload using secret
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// transitionEvent returns an event describing the state change in given action
+func transitionEvent(action *Action) Event {
+	prefixes := make([]string, len(action.Prefixes))
+	for i, p := range action.Prefixes {
+		prefixes[i] = p.String()
+	}
+
+	event := Event{
+		Type:     EventTypeTransition,
+		Service:  action.Service.Name(),
+		OldState: action.PreviousState,
+		NewState: action.State,
+		Prefixes: prefixes,
+	}
+
+	if result := action.Service.Status().LastResult; result != nil {
+		event.Output = outputExcerpt(result.Stdout + result.Stderr)
+	}
+
+	return event
+}
+
+// outputExcerpt returns the tail of given output
+func outputExcerpt(output string) string {
+	if len(output) > eventOutputSize {
+		return output[len(output)-eventOutputSize:]
+	}
+
+	return output
+}
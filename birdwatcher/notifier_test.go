@@ -0,0 +1,116 @@
+package birdwatcher
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifierDeliver(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan Event, 1)
+
+	var attempts atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail the first attempt to test retries
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "sha256="+signPayload("s3cr3t", body), r.Header.Get(webhookSignatureHeader))
+
+		var event Event
+		if assert.NoError(t, json.Unmarshal(body, &event)) {
+			received <- event
+		}
+	}))
+	defer srv.Close()
+
+	n := NewNotifier([]WebhookConfig{{URL: srv.URL, Secret: "s3cr3t", Timeout: time.Second, Retries: 2}})
+	n.retryDelay = time.Millisecond
+
+	go n.Start()
+	defer n.Stop()
+
+	n.Notify(Event{Type: EventTypeReloadFailure, Error: "foo"})
+
+	select {
+	case event := <-received:
+		assert.Equal(t, EventTypeReloadFailure, event.Type)
+		assert.Equal(t, "foo", event.Error)
+		assert.Equal(t, n.hostname, event.Hostname)
+		assert.False(t, event.Timestamp.IsZero())
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "event not delivered")
+	}
+
+	assert.Equal(t, int32(2), attempts.Load())
+}
+
+func TestNotifierNoWebhooks(t *testing.T) {
+	t.Parallel()
+
+	// nil notifier and notifier without webhooks should ignore events
+	var n *Notifier
+	n.Notify(Event{Type: EventTypeReloadFailure})
+
+	n = NewNotifier(nil)
+	n.Notify(Event{Type: EventTypeReloadFailure})
+	assert.Empty(t, n.events)
+}
+
+func TestTransitionEvent(t *testing.T) {
+	t.Parallel()
+
+	svc := &ServiceCheck{name: "foo", lastResult: &CheckResult{Stdout: "out", Stderr: "err"}}
+	action := &Action{
+		Service:       svc,
+		State:         ServiceStateDown,
+		PreviousState: ServiceStateUp,
+		Prefixes: []Prefix{
+			{IPNet: net.IPNet{IP: net.IP{1, 2, 3, 0}, Mask: net.IPMask{255, 255, 255, 0}}},
+		},
+	}
+
+	event := transitionEvent(action)
+	assert.Equal(t, EventTypeTransition, event.Type)
+	assert.Equal(t, "foo", event.Service)
+	assert.Equal(t, ServiceStateUp, event.OldState)
+	assert.Equal(t, ServiceStateDown, event.NewState)
+	assert.Equal(t, []string{"1.2.3.0/24"}, event.Prefixes)
+	assert.Equal(t, "outerr", event.Output)
+}
+
+func TestOutputExcerpt(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "foo", outputExcerpt("foo"))
+
+	long := make([]byte, eventOutputSize+10)
+	for i := range long {
+		long[i] = 'a'
+	}
+
+	long[len(long)-1] = 'b'
+	excerpt := outputExcerpt(string(long))
+	assert.Len(t, excerpt, eventOutputSize)
+	assert.Equal(t, byte('b'), excerpt[len(excerpt)-1])
+}
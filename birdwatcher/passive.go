@@ -0,0 +1,176 @@
+package birdwatcher
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ServiceTypeActive services run a check command to determine their state
+	ServiceTypeActive = "active"
+	// ServiceTypePassive services have their state pushed to them through the
+	// status API
+	ServiceTypePassive = "passive"
+)
+
+var (
+	errUnknownService = errors.New("unknown service")
+	errNotPassive     = errors.New("service is not passive")
+	errNotRunning     = errors.New("service is not running")
+	errUnauthorized   = errors.New("invalid secret")
+	errInvalidState   = errors.New("state should be up or down")
+)
+
+// PushRequest is the body of a request pushing the state of a passive service
+type PushRequest struct {
+	State  ServiceState `json:"state"`
+	Output string       `json:"output,omitempty"`
+}
+
+// validatePassive validates the settings of a passive service and sets its
+// defaults
+func validatePassive(s *ServiceCheck) error {
+	if s.Command != "" || s.Depends != "" {
+		return fmt.Errorf("service %s of type passive can not have command or depends set", s.name)
+	}
+
+	if s.TTL <= 0 {
+		return fmt.Errorf("service %s of type passive has no ttl set", s.name)
+	}
+
+	if s.Secret == "" {
+		return fmt.Errorf("service %s of type passive has no secret set", s.name)
+	}
+
+	switch s.Fallback {
+	case "":
+		s.Fallback = ServiceStateDown
+	case ServiceStateUp, ServiceStateDown:
+	default:
+		return fmt.Errorf("service %s has invalid fallback %s, should be up or down", s.name, s.Fallback)
+	}
+
+	s.pushes = make(chan PushRequest, 1)
+
+	return nil
+}
+
+// isPassive returns whether the state of the service is pushed to it
+func (s *ServiceCheck) isPassive() bool {
+	return s.Type == ServiceTypePassive
+}
+
+// startPassive waits for the state of the service to be pushed and falls back
+// to the configured state when no push arrives within the TTL
+func (s *ServiceCheck) startPassive(action *chan *Action) {
+	sLog := s.logger().WithField("ttl", s.TTL)
+
+	expired := time.NewTimer(s.TTL)
+	defer expired.Stop()
+
+	for {
+		var state ServiceState
+
+		select {
+		case <-s.stopped:
+			sLog.Debug("received stop signal")
+			// we're done
+			return
+
+		case req := <-s.pushes:
+			expired.Reset(s.TTL)
+
+			outcome := CheckOutcomeSuccess
+			if req.State != ServiceStateUp {
+				outcome = CheckOutcomeFailure
+			}
+
+			s.recordCheck(CheckResult{Outcome: outcome, Stdout: req.Output})
+
+			if outcome == CheckOutcomeSuccess {
+				serviceSuccessMetric.WithLabelValues(s.name).Inc()
+				serviceLastSuccessMetric.WithLabelValues(s.name).SetToCurrentTime()
+			} else {
+				serviceFailMetric.WithLabelValues(s.name).Inc()
+			}
+
+			sLog.WithField("state", req.State).Debug("received pushed state")
+
+			state = req.State
+
+		case <-expired.C:
+			sLog.WithField("fallback", s.Fallback).Warning("no state pushed within ttl, falling back")
+
+			state = s.Fallback
+		}
+
+		if a := s.transitionTo(state); a != nil {
+			sLog.Infof("service transitioning to %s", state)
+
+			*action <- a
+		}
+	}
+}
+
+// push passes the pushed state to the service. Only the latest push is kept
+// when the service didn't process the previous one yet.
+func (s *ServiceCheck) push(req PushRequest) error {
+	if s.pushes == nil {
+		return errNotRunning
+	}
+
+	for {
+		select {
+		case s.pushes <- req:
+			return nil
+		default:
+			// drop the pending push in favour of this newer one
+			select {
+			case <-s.pushes:
+			default:
+			}
+		}
+	}
+}
+
+// Push sets the state of given passive service, if given secret matches the
+// secret of the service
+func (h *HealthCheck) Push(name, secret string, req PushRequest) error {
+	h.mu.RLock()
+
+	var svc *ServiceCheck
+
+	for _, s := range h.services {
+		if s.name == name {
+			svc = s
+
+			break
+		}
+	}
+
+	h.mu.RUnlock()
+
+	switch {
+	case svc == nil:
+		return errUnknownService
+	case !svc.isPassive():
+		return errNotPassive
+	case subtle.ConstantTimeCompare([]byte(secret), []byte(svc.Secret)) != 1:
+		log.WithField("service", name).Warning("refusing push with invalid secret")
+
+		return errUnauthorized
+	case req.State != ServiceStateUp && req.State != ServiceStateDown:
+		return errInvalidState
+	}
+
+	// only keep the tail of the output, like for check commands
+	if len(req.Output) > checkOutputSize {
+		req.Output = req.Output[len(req.Output)-checkOutputSize:]
+	}
+
+	return svc.push(req)
+}
@@ -0,0 +1,91 @@
+package birdwatcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassiveService(t *testing.T) {
+	t.Parallel()
+
+	svc := &ServiceCheck{name: "passive_foo", Type: ServiceTypePassive, TTL: 200 * time.Millisecond, Secret: "s3cr3t"}
+	require.NoError(t, validatePassive(svc))
+	assert.Equal(t, ServiceStateDown, svc.Fallback)
+
+	hc := NewHealthCheck(Config{})
+	hc.services = []*ServiceCheck{svc, {name: "active"}}
+
+	actions := make(chan *Action)
+	go svc.Start(&actions)
+	defer svc.Stop()
+
+	push := func(name, secret, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/services/"+name+"/state", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+secret)
+
+		rec := httptest.NewRecorder()
+		NewAPIHandler(hc).ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusNoContent, push("passive_foo", "s3cr3t", `{"state": "up", "output": "site healthy"}`))
+
+	action := <-actions
+	assert.Equal(t, ServiceStateUp, action.State)
+	assert.Equal(t, svc, action.Service)
+
+	status := svc.Status()
+	assert.Equal(t, ServiceTypePassive, status.Type)
+	assert.Equal(t, uint64(1), status.Successes)
+	if assert.NotNil(t, status.LastResult) {
+		assert.Equal(t, "site healthy", status.LastResult.Stdout)
+	}
+
+	// without pushes, the service falls back to down after the ttl
+	start := time.Now()
+	action = <-actions
+	assert.Equal(t, ServiceStateDown, action.State)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	// invalid pushes
+	assert.Equal(t, http.StatusUnauthorized, push("passive_foo", "wrong", `{"state": "up"}`))
+	assert.Equal(t, http.StatusBadRequest, push("passive_foo", "s3cr3t", `{"state": "degraded"}`))
+	assert.Equal(t, http.StatusBadRequest, push("passive_foo", "s3cr3t", `not json`))
+	assert.Equal(t, http.StatusNotFound, push("unknown", "s3cr3t", `{"state": "up"}`))
+	assert.Equal(t, http.StatusNotFound, push("active", "s3cr3t", `{"state": "up"}`))
+}
+
+func TestPassiveServiceFallbackUp(t *testing.T) {
+	t.Parallel()
+
+	svc := &ServiceCheck{name: "passive_bar", Type: ServiceTypePassive, TTL: 50 * time.Millisecond, Secret: "s3cr3t", Fallback: ServiceStateUp}
+	require.NoError(t, validatePassive(svc))
+
+	actions := make(chan *Action)
+	go svc.Start(&actions)
+	defer svc.Stop()
+
+	// service falls back to up when no state is pushed at all
+	action := <-actions
+	assert.Equal(t, ServiceStateUp, action.State)
+}
+
+func TestServiceCheckPush(t *testing.T) {
+	t.Parallel()
+
+	// pushing to a service that isn't set up for it
+	assert.ErrorIs(t, (&ServiceCheck{}).push(PushRequest{State: ServiceStateUp}), errNotRunning)
+
+	// only the latest push is kept
+	svc := &ServiceCheck{pushes: make(chan PushRequest, 1)}
+	require.NoError(t, svc.push(PushRequest{State: ServiceStateUp}))
+	require.NoError(t, svc.push(PushRequest{State: ServiceStateDown}))
+	assert.Equal(t, ServiceStateDown, (<-svc.pushes).State)
+}
@@ -0,0 +1,357 @@
+package birdwatcher
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPeeringInterval = 10 * time.Second
+	defaultPeeringTimeout  = 2 * time.Second
+
+	// PeeringStatePath is the path of the API endpoint peers fetch the state
+	// of this node from
+	PeeringStatePath = "/api/v1/peering/state"
+)
+
+var (
+	peerUpMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "peer",
+		Name:      "up",
+		Help:      "Whether the state of a peer could be fetched",
+	}, []string{"peer"})
+
+	peerLastSeenMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "peer",
+		Name:      "last_seen_timestamp_seconds",
+		Help:      "Unix timestamp of the last time the state of a peer was fetched",
+	}, []string{"peer"})
+
+	peerServiceStateMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "peer",
+		Name:      "service_state",
+		Help:      "Current health state per service per peer",
+	}, []string{"peer", "service"})
+)
+
+// PeeringConfig holds configuration of exchanging service states with other
+// birdwatcher instances
+type PeeringConfig struct {
+	Enabled  bool
+	Node     string
+	Peers    []string
+	Secret   string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// NodeState holds the states of the services of a birdwatcher instance
+type NodeState struct {
+	Node     string                  `json:"node"`
+	Services map[string]ServiceState `json:"services"`
+	// Withdrawn holds the services that are down and have their prefixes
+	// withdrawn, unlike services of which the prefixes are held
+	Withdrawn []string `json:"withdrawn"`
+}
+
+// PeerStatus holds the last known state of a peer
+type PeerStatus struct {
+	URL       string                  `json:"url"`
+	Node      string                  `json:"node,omitempty"`
+	Up        bool                    `json:"up"`
+	LastSeen  time.Time               `json:"last_seen"`
+	LastError string                  `json:"last_error,omitempty"`
+	Services  map[string]ServiceState `json:"services"`
+	Withdrawn []string                `json:"withdrawn"`
+}
+
+// withdrew returns whether the peer withdrew the prefixes of given service.
+// Peers not reporting their withdrawn services are assumed to have withdrawn
+// the services that are not up.
+func (s PeerStatus) withdrew(service string) bool {
+	if s.Withdrawn == nil {
+		state, found := s.Services[service]
+
+		return found && state != ServiceStateUp
+	}
+
+	return slices.Contains(s.Withdrawn, service)
+}
+
+// validatePeering validates the peering configuration and sets its defaults
+func validatePeering(c *PeeringConfig, api APIConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if !api.Enabled {
+		return errors.New("peering requires the api to be enabled")
+	}
+
+	for _, peer := range c.Peers {
+		u, err := url.Parse(peer)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("peering has invalid peer url %q", peer)
+		}
+	}
+
+	if c.Interval <= 0 {
+		c.Interval = defaultPeeringInterval
+	}
+
+	if c.Timeout <= 0 {
+		c.Timeout = defaultPeeringTimeout
+	}
+
+	if c.Node == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("peering has no node set and hostname is unknown: %w", err)
+		}
+
+		c.Node = hostname
+	}
+
+	return nil
+}
+
+// Peering periodically fetches the service states of peers
+type Peering struct {
+	config  PeeringConfig
+	client  *http.Client
+	stopped chan any
+
+	mu    sync.RWMutex
+	peers map[string]*PeerStatus
+}
+
+// NewPeering returns Peering for given configuration, or nil if disabled
+func NewPeering(c PeeringConfig) *Peering {
+	if !c.Enabled {
+		return nil
+	}
+
+	p := &Peering{
+		config:  c,
+		client:  &http.Client{Timeout: c.Timeout},
+		stopped: make(chan any),
+		peers:   make(map[string]*PeerStatus, len(c.Peers)),
+	}
+
+	for _, peer := range c.Peers {
+		p.peers[peer] = &PeerStatus{URL: peer}
+	}
+
+	return p
+}
+
+// Start periodically fetches the state of all peers until stopped
+func (p *Peering) Start() {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	p.poll()
+
+	for {
+		select {
+		case <-p.stopped:
+			return
+		case <-ticker.C:
+			p.poll()
+		}
+	}
+}
+
+// Stop stops fetching the state of peers
+func (p *Peering) Stop() {
+	if p == nil {
+		return
+	}
+
+	p.stopped <- true
+}
+
+// poll fetches the state of all peers concurrently
+func (p *Peering) poll() {
+	var wg sync.WaitGroup
+
+	for _, peer := range p.config.Peers {
+		wg.Add(1)
+
+		go func(peer string) {
+			defer wg.Done()
+
+			state, err := p.fetch(peer)
+			p.update(peer, state, err)
+		}(peer)
+	}
+
+	wg.Wait()
+}
+
+// fetch fetches the state of given peer
+func (p *Peering) fetch(peer string) (NodeState, error) {
+	var state NodeState
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(peer, "/")+PeeringStatePath, nil)
+	if err != nil {
+		return state, err
+	}
+
+	if p.config.Secret != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.Secret)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return state, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return state, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return state, fmt.Errorf("invalid response: %w", err)
+	}
+
+	return state, nil
+}
+
+// update records the outcome of fetching the state of given peer
+func (p *Peering) update(peer string, state NodeState, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := p.peers[peer]
+
+	if err != nil {
+		if status.Up {
+			log.WithError(err).WithField("peer", peer).Warning("could not fetch state of peer")
+		}
+
+		status.Up = false
+		status.LastError = err.Error()
+		peerUpMetric.WithLabelValues(peer).Set(0)
+
+		return
+	}
+
+	if !status.Up {
+		log.WithFields(log.Fields{
+			"peer": peer,
+			"node": state.Node,
+		}).Info("fetched state of peer")
+	}
+
+	// services the peer no longer has
+	for service := range status.Services {
+		if _, found := state.Services[service]; !found {
+			peerServiceStateMetric.DeleteLabelValues(peer, service)
+		}
+	}
+
+	status.Node = state.Node
+	status.Up = true
+	status.LastSeen = time.Now()
+	status.LastError = ""
+	status.Services = state.Services
+	status.Withdrawn = state.Withdrawn
+
+	peerUpMetric.WithLabelValues(peer).Set(1)
+	peerLastSeenMetric.WithLabelValues(peer).SetToCurrentTime()
+
+	for service, serviceState := range state.Services {
+		if serviceState == ServiceStateUp {
+			peerServiceStateMetric.WithLabelValues(peer, service).Set(1)
+		} else {
+			peerServiceStateMetric.WithLabelValues(peer, service).Set(0)
+		}
+	}
+}
+
+// Peers returns the last known state of all peers, sorted by URL
+func (p *Peering) Peers() []PeerStatus {
+	if p == nil {
+		return []PeerStatus{}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	peers := make([]PeerStatus, 0, len(p.peers))
+	for _, status := range p.peers {
+		peers = append(peers, *status)
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].URL < peers[j].URL
+	})
+
+	return peers
+}
+
+// authorized returns whether given request carries the shared secret, if
+// one is configured
+func (p *Peering) authorized(r *http.Request) bool {
+	if p.config.Secret == "" {
+		return true
+	}
+
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return found && subtle.ConstantTimeCompare([]byte(token), []byte(p.config.Secret)) == 1
+}
+
+// Withdrawn returns the nodes of reachable peers that report to have withdrawn
+// the prefixes of given service, so peering can be used as WithdrawalStore for
+// the circuit breaker. Peers keeping the prefixes of a service announced while
+// it is down are not counted.
+func (p *Peering) Withdrawn(service string) ([]string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var nodes []string
+
+	for _, status := range p.peers {
+		if status.Up && status.withdrew(service) {
+			nodes = append(nodes, status.Node)
+		}
+	}
+
+	sort.Strings(nodes)
+
+	return nodes, nil
+}
+
+// MarkWithdrawn does nothing, since peers fetch the state of this node
+func (p *Peering) MarkWithdrawn(_, _ string) error {
+	return nil
+}
+
+// ClearWithdrawn does nothing, since peers fetch the state of this node
+func (p *Peering) ClearWithdrawn(_, _ string) error {
+	return nil
+}
@@ -0,0 +1,314 @@
+package birdwatcher
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startPeers starts given number of birdwatcher instances on loopback, all
+// peering with each other
+func startPeers(t *testing.T, count int, secret string) ([]*HealthCheck, []string) {
+	t.Helper()
+
+	listeners := make([]net.Listener, count)
+	urls := make([]string, count)
+
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		listeners[i] = l
+		urls[i] = "http://" + l.Addr().String()
+	}
+
+	nodes := make([]*HealthCheck, count)
+
+	for i := range nodes {
+		var peers []string
+
+		for j, u := range urls {
+			if j != i {
+				peers = append(peers, u)
+			}
+		}
+
+		conf := Config{API: APIConfig{Enabled: true}, Peering: PeeringConfig{
+			Enabled: true,
+			Node:    "node" + strconv.Itoa(i+1),
+			Peers:   peers,
+			Secret:  secret,
+		}}
+		require.NoError(t, validatePeering(&conf.Peering, conf.API))
+
+		nodes[i] = NewHealthCheck(conf)
+
+		srv := httptest.NewUnstartedServer(NewAPIHandler(nodes[i]))
+		srv.Listener.Close()
+		srv.Listener = listeners[i]
+		srv.Start()
+		t.Cleanup(srv.Close)
+	}
+
+	return nodes, urls
+}
+
+func TestPeering(t *testing.T) {
+	t.Parallel()
+
+	nodes, urls := startPeers(t, 3, "s3cr3t")
+
+	// every node runs service web, which is only down on the last node
+	for i, h := range nodes {
+		state := ServiceStateUp
+		if i == len(nodes)-1 {
+			state = ServiceStateDown
+		}
+
+		h.services = []*ServiceCheck{{name: "web", state: state}}
+	}
+
+	for _, h := range nodes {
+		h.peering.poll()
+	}
+
+	// peers are sorted by URL, which depends on the ports assigned
+	byNode := func(peers []PeerStatus) map[string]PeerStatus {
+		m := make(map[string]PeerStatus, len(peers))
+		for _, p := range peers {
+			m[p.Node] = p
+		}
+
+		return m
+	}
+
+	peers := byNode(nodes[0].Peers())
+	if assert.Len(t, peers, 2) {
+		assert.Equal(t, urls[1], peers["node2"].URL)
+		assert.True(t, peers["node2"].Up)
+		assert.WithinDuration(t, time.Now(), peers["node2"].LastSeen, time.Minute)
+		assert.Equal(t, map[string]ServiceState{"web": ServiceStateUp}, peers["node2"].Services)
+
+		assert.Equal(t, urls[2], peers["node3"].URL)
+		assert.Equal(t, map[string]ServiceState{"web": ServiceStateDown}, peers["node3"].Services)
+	}
+
+	assert.InEpsilon(t, 1.0, testutil.ToFloat64(peerUpMetric.WithLabelValues(urls[1])), 0.00001)
+	assert.InEpsilon(t, 1.0, testutil.ToFloat64(peerServiceStateMetric.WithLabelValues(urls[1], "web")), 0.00001)
+	assert.Empty(t, testutil.ToFloat64(peerServiceStateMetric.WithLabelValues(urls[2], "web")))
+
+	// peering can serve as withdrawal store
+	withdrawn, err := nodes[0].peering.Withdrawn("web")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node3"}, withdrawn)
+
+	withdrawn, err = nodes[2].peering.Withdrawn("web")
+	require.NoError(t, err)
+	assert.Empty(t, withdrawn)
+
+	// the peers are exposed in the status API
+	rec := httptest.NewRecorder()
+	NewAPIHandler(nodes[1]).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/peers", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var statuses []PeerStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+
+	assert.Contains(t, byNode(statuses), "node1")
+	assert.Contains(t, byNode(statuses), "node3")
+
+	// node going away is noticed by its peers
+	nodes[1].peering.client.Timeout = 100 * time.Millisecond
+	nodes[1].peering.config.Peers = append(nodes[1].peering.config.Peers, "http://127.0.0.1:1")
+	nodes[1].peering.peers["http://127.0.0.1:1"] = &PeerStatus{URL: "http://127.0.0.1:1", Up: true}
+	nodes[1].peering.poll()
+
+	statuses = nodes[1].Peers()
+	if assert.Len(t, statuses, 3) {
+		// unreachable peer sorts first
+		assert.Equal(t, "http://127.0.0.1:1", statuses[0].URL)
+		assert.False(t, statuses[0].Up)
+		assert.NotEmpty(t, statuses[0].LastError)
+	}
+
+	assert.Empty(t, testutil.ToFloat64(peerUpMetric.WithLabelValues("http://127.0.0.1:1")))
+}
+
+func TestPeeringCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	nodes, _ := startPeers(t, 2, "")
+
+	_, prefix, _ := parsePrefix("10.2.0.0/24")
+	services := make([]*ServiceCheck, len(nodes))
+
+	for i, h := range nodes {
+		services[i] = &ServiceCheck{name: "peered", FunctionName: "match_peered", prefixes: []Prefix{prefix}}
+		h.services = []*ServiceCheck{services[i]}
+		h.breaker = newCircuitBreaker(CircuitBreakerConfig{
+			Enabled:      true,
+			Store:        WithdrawalStorePeers,
+			MaxWithdrawn: 1,
+			Node:         h.Config.Peering.Node,
+		}, h.peering)
+	}
+
+	sc := make(chan string)
+	go func() {
+		// make sure to read the status channel to prevent blocking handleAction
+		for range sc {
+		}
+	}()
+	defer close(sc)
+
+	transition := func(i int, state ServiceState) {
+		services[i].setState(state)
+		nodes[i].handleAction(services[i].getAction(), sc)
+	}
+
+	transition(0, ServiceStateUp)
+	transition(1, ServiceStateUp)
+
+	transition(0, ServiceStateDown)
+	assert.Empty(t, nodes[0].prefixes["match_peered"].prefixes)
+
+	// second node learns its peer withdrew and keeps announcing
+	nodes[1].peering.poll()
+	transition(1, ServiceStateDown)
+	assert.Len(t, nodes[1].prefixes["match_peered"].prefixes, 1)
+
+	// until its peer is up again
+	transition(0, ServiceStateUp)
+	nodes[1].peering.poll()
+	assert.True(t, nodes[1].releaseHeld())
+	assert.Empty(t, nodes[1].prefixes["match_peered"].prefixes)
+}
+
+func TestPeeringCircuitBreakerSimultaneous(t *testing.T) {
+	t.Parallel()
+
+	nodes, _ := startPeers(t, 2, "")
+
+	_, prefix, _ := parsePrefix("10.3.0.0/24")
+	services := make([]*ServiceCheck, len(nodes))
+
+	for i, h := range nodes {
+		services[i] = &ServiceCheck{name: "peered", FunctionName: "match_peered", prefixes: []Prefix{prefix}}
+		h.services = []*ServiceCheck{services[i]}
+		h.breaker = newCircuitBreaker(CircuitBreakerConfig{
+			Enabled:      true,
+			Store:        WithdrawalStorePeers,
+			MaxWithdrawn: 1,
+			Node:         h.Config.Peering.Node,
+		}, h.peering)
+	}
+
+	sc := make(chan string)
+	go func() {
+		// make sure to read the status channel to prevent blocking handleAction
+		for range sc {
+		}
+	}()
+	defer close(sc)
+
+	for i, h := range nodes {
+		services[i].setState(ServiceStateUp)
+		h.handleAction(services[i].getAction(), sc)
+	}
+
+	// both nodes fail at the same time and learn about each other being down
+	// before handling the transition
+	for _, s := range services {
+		s.setState(ServiceStateDown)
+	}
+
+	for _, h := range nodes {
+		h.peering.poll()
+	}
+
+	// the peer still announces its prefixes, so the first node withdraws
+	nodes[0].handleAction(services[0].getAction(), sc)
+	assert.Empty(t, nodes[0].prefixes["match_peered"].prefixes)
+
+	// the second node learns its peer withdrew and holds its prefixes
+	nodes[1].peering.poll()
+	nodes[1].handleAction(services[1].getAction(), sc)
+	assert.Len(t, nodes[1].prefixes["match_peered"].prefixes, 1)
+
+	// held prefixes don't count as withdrawn
+	nodes[0].peering.poll()
+
+	withdrawn, err := nodes[0].peering.Withdrawn("peered")
+	require.NoError(t, err)
+	assert.Empty(t, withdrawn)
+
+	withdrawn, err = nodes[1].peering.Withdrawn("peered")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node1"}, withdrawn)
+}
+
+func TestPeeringStateEndpoint(t *testing.T) {
+	t.Parallel()
+
+	// peering disabled
+	rec := httptest.NewRecorder()
+	NewAPIHandler(NewHealthCheck(Config{})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, PeeringStatePath, nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	hc := NewHealthCheck(Config{Peering: PeeringConfig{Enabled: true, Node: "node1", Secret: "s3cr3t"}})
+	hc.services = []*ServiceCheck{{name: "foo", state: ServiceStateUp}, {name: "bar"}}
+
+	// missing or wrong secret
+	for _, auth := range []string{"", "Bearer wrong", "s3cr3t"} {
+		req := httptest.NewRequest(http.MethodGet, PeeringStatePath, nil)
+		req.Header.Set("Authorization", auth)
+
+		rec = httptest.NewRecorder()
+		NewAPIHandler(hc).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, auth)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, PeeringStatePath, nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+
+	rec = httptest.NewRecorder()
+	NewAPIHandler(hc).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var state NodeState
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+	assert.Equal(t, NodeState{
+		Node:      "node1",
+		Services:  map[string]ServiceState{"foo": ServiceStateUp, "bar": ServiceStateDown},
+		Withdrawn: []string{"bar"},
+	}, state)
+}
+
+func TestPeerStatusWithdrew(t *testing.T) {
+	t.Parallel()
+
+	status := PeerStatus{
+		Services:  map[string]ServiceState{"foo": ServiceStateUp, "bar": ServiceStateDown, "baz": ServiceStateDown},
+		Withdrawn: []string{"baz"},
+	}
+
+	// services that are down may still have their prefixes held
+	assert.False(t, status.withdrew("foo"))
+	assert.False(t, status.withdrew("bar"))
+	assert.True(t, status.withdrew("baz"))
+	assert.False(t, status.withdrew("unknown"))
+
+	// peers not reporting withdrawn services are judged by their states
+	status.Withdrawn = nil
+	assert.False(t, status.withdrew("foo"))
+	assert.True(t, status.withdrew("bar"))
+	assert.False(t, status.withdrew("unknown"))
+}
@@ -0,0 +1,126 @@
+package birdwatcher
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PrefixRange is the range of prefix lengths matched by a Prefix
+type PrefixRange struct {
+	Low  int
+	High int
+}
+
+// Prefix is a network, optionally with a range of prefix lengths of more or
+// less specific networks it matches, following the prefix patterns of BIRD:
+// 10.0.0.0/16{24,32}, 10.0.0.0/16+ or 10.0.0.0/16-
+type Prefix struct {
+	net.IPNet
+	// Range is nil when only the network itself is matched
+	Range *PrefixRange
+}
+
+// parsePrefix parses given prefix pattern. Like net.ParseCIDR, it returns the
+// IP address as well, so host bits set in the prefix can be detected.
+func parsePrefix(s string) (net.IP, Prefix, error) {
+	cidr, pattern := s, ""
+
+	switch {
+	case strings.HasSuffix(s, "+"), strings.HasSuffix(s, "-"):
+		cidr, pattern = s[:len(s)-1], s[len(s)-1:]
+	case strings.HasSuffix(s, "}"):
+		i := strings.LastIndex(s, "{")
+		if i < 0 {
+			return nil, Prefix{}, fmt.Errorf("invalid prefix pattern %s", s)
+		}
+
+		cidr, pattern = s[:i], s[i:]
+	}
+
+	ip, ipn, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, Prefix{}, err
+	}
+
+	prefix := Prefix{IPNet: *ipn}
+	ones, bits := ipn.Mask.Size()
+
+	switch pattern {
+	case "":
+		return ip, prefix, nil
+	case "+":
+		prefix.Range = &PrefixRange{Low: ones, High: bits}
+	case "-":
+		prefix.Range = &PrefixRange{Low: 0, High: ones}
+	default:
+		low, high, found := strings.Cut(strings.Trim(pattern, "{}"), ",")
+		if !found {
+			return nil, Prefix{}, fmt.Errorf("invalid prefix pattern %s", s)
+		}
+
+		r := &PrefixRange{}
+		if r.Low, err = strconv.Atoi(strings.TrimSpace(low)); err != nil {
+			return nil, Prefix{}, fmt.Errorf("invalid prefix pattern %s", s)
+		}
+
+		if r.High, err = strconv.Atoi(strings.TrimSpace(high)); err != nil {
+			return nil, Prefix{}, fmt.Errorf("invalid prefix pattern %s", s)
+		}
+
+		if r.Low < 0 || r.Low > r.High || r.High > bits {
+			return nil, Prefix{}, fmt.Errorf("invalid prefix pattern %s, range should be within 0 and %d", s, bits)
+		}
+
+		prefix.Range = r
+	}
+
+	// a range only matching the network itself is no range at all
+	if prefix.Range.Low == ones && prefix.Range.High == ones {
+		prefix.Range = nil
+	}
+
+	return ip, prefix, nil
+}
+
+// String returns the prefix in the prefix pattern notation of BIRD
+func (p Prefix) String() string {
+	s := p.IPNet.String()
+	if p.Range == nil {
+		return s
+	}
+
+	ones, bits := p.Mask.Size()
+
+	switch {
+	case p.Range.Low == ones && p.Range.High == bits:
+		return s + "+"
+	case p.Range.Low == 0 && p.Range.High == ones:
+		return s + "-"
+	}
+
+	return fmt.Sprintf("%s{%d,%d}", s, p.Range.Low, p.Range.High)
+}
+
+// Equal returns whether given prefix is the same network with the same range
+func (p Prefix) Equal(o Prefix) bool {
+	if !p.IP.Equal(o.IP) || !bytes.Equal(p.Mask, o.Mask) {
+		return false
+	}
+
+	if p.Range == nil || o.Range == nil {
+		return p.Range == o.Range
+	}
+
+	return *p.Range == *o.Range
+}
+
+// matchesShorter returns whether the prefix matches networks less specific
+// than itself
+func (p Prefix) matchesShorter() bool {
+	ones, _ := p.Mask.Size()
+
+	return p.Range != nil && p.Range.Low < ones
+}
@@ -0,0 +1,78 @@
+package birdwatcher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePrefix(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input    string
+		expected string
+		r        *PrefixRange
+	}{
+		{"10.0.0.0/16", "10.0.0.0/16", nil},
+		{"10.0.0.0/16{24,32}", "10.0.0.0/16{24,32}", &PrefixRange{Low: 24, High: 32}},
+		{"10.0.0.0/16{ 24, 28 }", "10.0.0.0/16{24,28}", &PrefixRange{Low: 24, High: 28}},
+		{"10.0.0.0/16+", "10.0.0.0/16+", &PrefixRange{Low: 16, High: 32}},
+		{"10.0.0.0/16-", "10.0.0.0/16-", &PrefixRange{Low: 0, High: 16}},
+		{"10.0.0.0/16{16,32}", "10.0.0.0/16+", &PrefixRange{Low: 16, High: 32}},
+		{"10.0.0.0/16{16,16}", "10.0.0.0/16", nil},
+		{"2001:db8::/32+", "2001:db8::/32+", &PrefixRange{Low: 32, High: 128}},
+		{"2001:db8::/32{48,64}", "2001:db8::/32{48,64}", &PrefixRange{Low: 48, High: 64}},
+	}
+
+	for _, test := range tests {
+		_, prefix, err := parsePrefix(test.input)
+		require.NoError(t, err, test.input)
+
+		assert.Equal(t, test.expected, prefix.String(), test.input)
+		assert.Equal(t, test.r, prefix.Range, test.input)
+	}
+
+	// the address is returned as is, to detect host bits
+	ip, prefix, err := parsePrefix("10.0.0.1/16+")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ip.String())
+	assert.Equal(t, "10.0.0.0/16+", prefix.String())
+
+	for _, input := range []string{
+		"foobar",
+		"10.0.0.0",
+		"10.0.0.0/16}",
+		"10.0.0.0/16{24}",
+		"10.0.0.0/16{24,a}",
+		"10.0.0.0/16{32,24}",
+		"10.0.0.0/16{24,33}",
+		"2001:db8::/32{48,129}",
+		"10.0.0.0/16++",
+	} {
+		_, _, err := parsePrefix(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestPrefix_Equal(t *testing.T) {
+	t.Parallel()
+
+	_, a, _ := parsePrefix("10.0.0.0/16")
+	_, b, _ := parsePrefix("10.0.0.0/16+")
+	_, c, _ := parsePrefix("10.0.0.0/16{16,32}")
+	_, d, _ := parsePrefix("10.0.0.0/16{24,32}")
+
+	assert.True(t, a.Equal(a))
+	assert.False(t, a.Equal(b))
+	assert.False(t, b.Equal(a))
+	assert.True(t, b.Equal(c))
+	assert.False(t, c.Equal(d))
+
+	assert.False(t, a.matchesShorter())
+	assert.False(t, d.matchesShorter())
+
+	_, e, _ := parsePrefix("10.0.0.0/16-")
+	assert.True(t, e.matchesShorter())
+}
@@ -1,10 +1,8 @@
 package birdwatcher
 
 import (
-	"bytes"
 	// use embed for embedding the function template
 	_ "embed"
-	"net"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -14,7 +12,7 @@ type PrefixCollection map[string]*PrefixSet
 
 // PrefixSet represents a list of prefixes alongside a function name
 type PrefixSet struct {
-	prefixes     []net.IPNet
+	prefixes     []Prefix
 	functionName string
 }
 
@@ -29,12 +27,12 @@ func (p PrefixSet) FunctionName() string {
 }
 
 // Prefixes returns the prefixes
-func (p PrefixSet) Prefixes() []net.IPNet {
+func (p PrefixSet) Prefixes() []Prefix {
 	return p.prefixes
 }
 
 // Add adds a prefix to the PrefixSet if it wasn't already in it
-func (p *PrefixSet) Add(prefix net.IPNet) {
+func (p *PrefixSet) Add(prefix Prefix) {
 	pLog := log.WithFields(log.Fields{
 		"prefix": prefix,
 	})
@@ -43,7 +41,7 @@ func (p *PrefixSet) Add(prefix net.IPNet) {
 	// skip prefix if it's already in the list
 	// shouldn't really happen though
 	for _, pref := range p.prefixes {
-		if pref.IP.Equal(prefix.IP) && bytes.Equal(pref.Mask, prefix.Mask) {
+		if pref.Equal(prefix) {
 			pLog.Warn("duplicate prefix, skipping")
 
 			return
@@ -54,8 +52,19 @@ func (p *PrefixSet) Add(prefix net.IPNet) {
 	p.prefixes = append(p.prefixes, prefix)
 }
 
+// Contains returns whether the prefix is in the PrefixSet
+func (p PrefixSet) Contains(prefix Prefix) bool {
+	for _, pref := range p.prefixes {
+		if pref.Equal(prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Remove removes a prefix from the PrefixSet
-func (p *PrefixSet) Remove(prefix net.IPNet) {
+func (p *PrefixSet) Remove(prefix Prefix) {
 	pLog := log.WithFields(log.Fields{
 		"prefix": prefix,
 	})
@@ -63,7 +72,7 @@ func (p *PrefixSet) Remove(prefix net.IPNet) {
 
 	// go over global prefix list and remove it when found
 	for i, pref := range p.prefixes {
-		if pref.IP.Equal(prefix.IP) && bytes.Equal(pref.Mask, prefix.Mask) {
+		if pref.Equal(prefix) {
 			// remove entry from slice, fast approach
 			p.prefixes[i] = p.prefixes[len(p.prefixes)-1] // copy last element to index i
 			p.prefixes = p.prefixes[:len(p.prefixes)-1]   // truncate slice
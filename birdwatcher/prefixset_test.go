@@ -1,7 +1,6 @@
 package birdwatcher
 
 import (
-	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -16,8 +15,8 @@ func TestPrefixSet_Add(t *testing.T) {
 
 	// add some prefixes
 	for _, pref := range []string{"1.2.3.0/24", "2.3.4.0/24", "3.4.5.0/24", "3.4.5.0/26"} {
-		_, prf, _ := net.ParseCIDR(pref)
-		p.Add(*prf)
+		_, prf, _ := parsePrefix(pref)
+		p.Add(prf)
 	}
 
 	// check if all 4 prefixes are there
@@ -29,8 +28,8 @@ func TestPrefixSet_Add(t *testing.T) {
 	}
 
 	// try to add a duplicate prefix
-	_, prf, _ := net.ParseCIDR("1.2.3.0/24")
-	p.Add(*prf)
+	_, prf, _ := parsePrefix("1.2.3.0/24")
+	p.Add(prf)
 
 	// this shouldn't have changed the content of the PrefixSet
 	if assert.Len(t, p.prefixes, 4) {
@@ -47,12 +46,12 @@ func TestPrefixSet_Remove(t *testing.T) {
 	p := NewPrefixSet("foobar")
 
 	// add some prefixes
-	prefixes := make([]net.IPNet, 4)
+	prefixes := make([]Prefix, 4)
 
 	for i, pref := range []string{"1.2.3.0/24", "2.3.4.0/24", "3.4.5.0/24", "3.4.5.0/26"} {
-		_, prf, _ := net.ParseCIDR(pref)
-		p.Add(*prf)
-		prefixes[i] = *prf
+		_, prf, _ := parsePrefix(pref)
+		p.Add(prf)
+		prefixes[i] = prf
 	}
 
 	// remove last prefix
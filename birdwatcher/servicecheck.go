@@ -3,10 +3,14 @@ package birdwatcher
 import (
 	"context"
 	"errors"
-	"net"
+	"os"
 	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -22,13 +26,6 @@ var (
 		Help:      "Services and their configuration",
 	}, []string{"service", "function_name", "command", "interval", "timeout", "rise", "fail"})
 
-	serviceCheckDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "birdwatcher",
-		Subsystem: "service",
-		Name:      "check_duration",
-		Help:      "Service check duration in milliseconds",
-	}, []string{"service"})
-
 	serviceStateMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "birdwatcher",
 		Subsystem: "service",
@@ -63,8 +60,79 @@ var (
 		Name:      "timeout_total",
 		Help:      "Number of timed out probes per service",
 	}, []string{"service"})
+
+	serviceDegradedMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "service",
+		Name:      "degraded_total",
+		Help:      "Number of probes reporting a degraded service per service",
+	}, []string{"service"})
+
+	serviceUnknownMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "service",
+		Name:      "unknown_total",
+		Help:      "Number of ignored probes with unknown outcome per service",
+	}, []string{"service"})
+
+	serviceLastSuccessMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "service",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful probe per service",
+	}, []string{"service"})
+
+	serviceLastTransitionMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "service",
+		Name:      "last_transition_timestamp_seconds",
+		Help:      "Unix timestamp of the last transition per service",
+	}, []string{"service"})
+
+	checkRunningMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "check",
+		Name:      "running",
+		Help:      "Number of check commands currently running",
+	})
+
+	checkQueuedMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "check",
+		Name:      "queued",
+		Help:      "Number of checks waiting for a slot because of maxconcurrentchecks",
+	})
+
+	checkQueueDelayMetric = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "birdwatcher",
+		Subsystem: "check",
+		Name:      "queue_delay_seconds",
+		Help:      "Time checks waited for a slot because of maxconcurrentchecks in seconds",
+	})
+
+	// the check duration histogram is registered once its buckets are known
+	serviceCheckDuration     *prometheus.HistogramVec
+	serviceCheckDurationOnce sync.Once
 )
 
+// registerCheckDurationMetric registers the check duration histogram with given
+// buckets, or the prometheus default buckets if none are given. Only the first
+// call has effect, since metrics can only be registered once.
+func registerCheckDurationMetric(buckets []float64) {
+	serviceCheckDurationOnce.Do(func() {
+		serviceCheckDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "birdwatcher",
+			Subsystem: "service",
+			Name:      "check_duration_seconds",
+			Help:      "Service check duration in seconds",
+			Buckets:   buckets,
+		}, []string{"service"})
+	})
+}
+
+// amount of bytes of the check command's stdout and stderr that are retained
+const checkOutputSize = 4096
+
 // ServiceState represents the state the service is considered to be in
 type ServiceState string
 
@@ -81,16 +149,88 @@ type ServiceCheck struct {
 	name         string
 	FunctionName string
 	Command      string
-	Interval     int
+	Args         []string
+	Shell        bool
+	Env          map[string]string
+	WorkDir      string
+	User         string
+	Group        string
+	Interval     time.Duration
+	FastInterval time.Duration
 	Timeout      time.Duration
 	Fail         int
 	Rise         int
 	Prefixes     []string
-	//nolint:revive // these prefixes are converted into net.IPNet
-	prefixes           []net.IPNet
-	state              ServiceState
+	PrefixFile   string
+	LogLevel     string
+	ExitCodes    ExitCodes
+	Depends      string
+	Type         string
+	TTL          time.Duration
+	Fallback     ServiceState
+	Secret       string
+	Inherit      string
+	//nolint:revive // these prefixes are converted into Prefix
+	prefixes []Prefix
+	// file the service is defined in
+	source             string
+	logLevel           *log.Level
+	credential         *syscall.Credential
+	depends            dependencyExpr
+	pushes             chan PushRequest
+	limiter            chan struct{}
+	startDelay         time.Duration
+	log                *log.Logger
 	disablePrefixCheck bool
 	stopped            chan any
+	// unix timestamp in nanoseconds of the last iteration of the check loop
+	lastProgress atomic.Int64
+	// set when the service was forced down, so the check loop counts its
+	// successes anew
+	forcedDown atomic.Bool
+
+	// mu protects the fields below, which are read by the status API
+	mu            sync.RWMutex
+	state         ServiceState
+	previousState ServiceState
+	successes     uint64
+	degraded      uint64
+	unknowns      uint64
+	failures      uint64
+	timeouts      uint64
+	transitions   uint64
+	lastCheck     time.Time
+	lastError     string
+	lastResult    *CheckResult
+}
+
+// CheckResult holds the outcome of a single execution of the check command
+type CheckResult struct {
+	Outcome  CheckOutcome  `json:"outcome"`
+	ExitCode int           `json:"exit_code"`
+	Stdout   string        `json:"stdout"`
+	Stderr   string        `json:"stderr"`
+	Duration time.Duration `json:"duration_ns"`
+	Err      error         `json:"-"`
+}
+
+// ServiceStatus is a snapshot of the state and counters of a ServiceCheck
+type ServiceStatus struct {
+	Name         string       `json:"name"`
+	FunctionName string       `json:"function_name"`
+	State        ServiceState `json:"state"`
+	Successes    uint64       `json:"successes"`
+	Degraded     uint64       `json:"degraded"`
+	Unknowns     uint64       `json:"unknowns"`
+	Failures     uint64       `json:"failures"`
+	Timeouts     uint64       `json:"timeouts"`
+	Transitions  uint64       `json:"transitions"`
+	LastCheck    time.Time    `json:"last_check"`
+	LastError    string       `json:"last_error,omitempty"`
+	LastResult   *CheckResult `json:"last_result,omitempty"`
+	Prefixes     []string     `json:"prefixes"`
+	Depends      string       `json:"depends,omitempty"`
+	Type         string       `json:"type,omitempty"`
 }
 
 // Start starts the process of health checking its service and sends actions to
@@ -99,29 +239,53 @@ type ServiceCheck struct {
 //nolint:funlen // we should refactor this a bit
 func (s *ServiceCheck) Start(action *chan *Action) {
 	s.stopped = make(chan any)
-	ticker := time.NewTicker(time.Second * time.Duration(s.Interval))
 
-	var err error
+	s.markProgress()
+
+	s.setupLogger()
+
+	// passive services don't run a check command
+	if s.isPassive() {
+		s.startPassive(action)
+
+		return
+	}
+
+	var (
+		result CheckResult
+		err    error
+	)
 
 	upCounter := 0
 	downCounter := 0
 
-	sLog := log.WithFields(log.Fields{
-		"service": s.name,
+	sLog := s.logger().WithFields(log.Fields{
 		"command": s.Command,
 	})
 
+	// make sure the check duration histogram is available
+	registerCheckDurationMetric(nil)
+
 	// set service info metric
 	serviceInfoMetric.With(prometheus.Labels{
 		"service":       s.name,
 		"function_name": s.FunctionName,
 		"command":       s.Command,
-		"interval":      strconv.Itoa(s.Interval),
+		"interval":      s.Interval.String(),
 		"timeout":       s.Timeout.String(),
 		"rise":          strconv.Itoa(s.Rise),
 		"fail":          strconv.Itoa(s.Fail),
 	}).Set(1.0)
 
+	interval := s.Interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// the first check is not delayed, the checks following it are shifted by
+	// the start delay
+	startDelay := s.startDelay
+	checked := false
+
 	for {
 		select {
 		case <-s.stopped:
@@ -130,38 +294,90 @@ func (s *ServiceCheck) Start(action *chan *Action) {
 			return
 
 		case <-ticker.C:
-			beginCheck := time.Now()
+			if checked && startDelay > 0 {
+				sLog.WithField("delay", startDelay).Debug("delaying checks of service")
+				s.markProgress()
+
+				select {
+				case <-s.stopped:
+					sLog.Debug("received stop signal")
+					return
+				case <-time.After(startDelay):
+				}
+
+				s.markProgress()
+				ticker.Reset(interval)
+
+				startDelay = 0
+			}
+
+			checked = true
+
+			// wait for a slot when the number of concurrent checks is limited
+			if !s.acquireSlot() {
+				sLog.Debug("received stop signal")
+				return
+			}
+
 			// perform check synchronously to prevent checks to queue
-			err = s.performCheck()
+			result = s.performCheck()
+			s.releaseSlot()
+			err = result.Err
 			// keep track of the time it took for the check to perform
-			serviceCheckDuration.WithLabelValues(s.name).Set(float64(time.Since(beginCheck)))
+			serviceCheckDuration.WithLabelValues(s.name).Observe(result.Duration.Seconds())
+			// keep track of the result for the status API
+			s.recordCheck(result)
+
+			// the check could not determine the health of the service, so
+			// ignore this sample altogether
+			if result.Outcome == CheckOutcomeUnknown {
+				serviceUnknownMetric.WithLabelValues(s.name).Inc()
+				sLog.WithField("exit_code", result.ExitCode).Debug("check command could not determine health, ignoring")
+
+				s.markProgress()
+
+				continue
+			}
+
+			// a service that was forced down has to rise again
+			if s.forcedDown.Swap(false) {
+				upCounter = 0
+				downCounter = 0
+			}
 
 			// based on the check result, decide if we're going up or down
 			//
 			// check gave positive result
-			if err == nil {
+			if result.Outcome != CheckOutcomeFailure {
 				// reset downCounter
 				downCounter = 0
 
-				// update success metric
+				// update success metrics
 				serviceSuccessMetric.WithLabelValues(s.name).Inc()
+				serviceLastSuccessMetric.WithLabelValues(s.name).SetToCurrentTime()
 
-				sLog.Debug("check command exited without error")
+				if result.Outcome == CheckOutcomeDegraded {
+					serviceDegradedMetric.WithLabelValues(s.name).Inc()
+					sLog.WithField("exit_code", result.ExitCode).Debug("check command reported service degraded")
+				} else {
+					sLog.Debug("check command exited without error")
+				}
 
 				// are we up enough to consider service to be healthy
 				if upCounter >= (s.Rise - 1) {
-					if s.state != ServiceStateUp {
-						sLog.WithFields(log.Fields{
+					if s.currentState() != ServiceStateUp {
+						sLog.WithFields(result.logFields()).WithFields(log.Fields{
 							"successes": upCounter,
 						}).Info("service transitioning to up")
 
 						// mark current state as up
-						s.state = ServiceStateUp
+						s.setState(ServiceStateUp)
 
 						// update state metric
 						serviceStateMetric.WithLabelValues(s.name).Set(1)
-						// update transition metric
+						// update transition metrics
 						serviceTransitionMetric.WithLabelValues(s.name).Inc()
+						serviceLastTransitionMetric.WithLabelValues(s.name).SetToCurrentTime()
 
 						// send action on channel
 						*action <- s.getAction()
@@ -192,18 +408,19 @@ func (s *ServiceCheck) Start(action *chan *Action) {
 
 				// are we down long enough to consider service down
 				if downCounter >= (s.Fail - 1) {
-					if s.state != ServiceStateDown {
-						sLog.WithFields(log.Fields{
+					if s.currentState() != ServiceStateDown {
+						sLog.WithFields(result.logFields()).WithFields(log.Fields{
 							"failures": downCounter,
 						}).Info("service transitioning to down")
 
 						// mark current state as down
-						s.state = ServiceStateDown
+						s.setState(ServiceStateDown)
 
 						// update state metric
 						serviceStateMetric.WithLabelValues(s.name).Set(0)
-						// update transition metric
+						// update transition metrics
 						serviceTransitionMetric.WithLabelValues(s.name).Inc()
+						serviceLastTransitionMetric.WithLabelValues(s.name).SetToCurrentTime()
 
 						// send action on channel
 						*action <- s.getAction()
@@ -216,17 +433,128 @@ func (s *ServiceCheck) Start(action *chan *Action) {
 					}).Debug("service moving towards down")
 				}
 			}
+
+			// check more often while the service is transitioning
+			state := s.currentState()
+			transitioning := (downCounter > 0 && state != ServiceStateDown) ||
+				(upCounter > 0 && state != ServiceStateUp)
+
+			if next := s.checkInterval(transitioning); next != interval {
+				sLog.WithField("interval", next).Debug("changing check interval")
+
+				interval = next
+				ticker.Reset(interval)
+			}
+
+			s.markProgress()
 		}
 	}
 }
 
+// checkInterval returns the interval to check the service at, which is the
+// fast interval while the service is transitioning
+func (s *ServiceCheck) checkInterval(transitioning bool) time.Duration {
+	if transitioning && s.FastInterval > 0 {
+		return s.FastInterval
+	}
+
+	return s.Interval
+}
+
+// markProgress records the check loop made progress
+func (s *ServiceCheck) markProgress() {
+	s.lastProgress.Store(time.Now().UnixNano())
+}
+
+// sinceProgress returns the time since the check loop last made progress
+func (s *ServiceCheck) sinceProgress() time.Duration {
+	return time.Since(time.Unix(0, s.lastProgress.Load()))
+}
+
+// maxLoopDuration returns how long a single iteration of the check loop may
+// take before the loop is considered stalled
+func (s *ServiceCheck) maxLoopDuration() time.Duration {
+	return s.Interval + s.Timeout + progressGrace
+}
+
 // Stop stops the service check from running
 func (s *ServiceCheck) Stop() {
 	s.stopped <- true
 
-	log.WithFields(log.Fields{
-		"service": s.name,
-	}).Debug("stopped service")
+	s.logger().Debug("stopped service")
+}
+
+// acquireSlot waits until a check may run without exceeding the global limit
+// of concurrent checks. It returns false when the service was stopped while
+// waiting.
+func (s *ServiceCheck) acquireSlot() bool {
+	if s.limiter != nil {
+		select {
+		case s.limiter <- struct{}{}:
+		default:
+			if !s.waitForSlot() {
+				return false
+			}
+		}
+	}
+
+	checkRunningMetric.Inc()
+
+	return true
+}
+
+// waitForSlot blocks until a slot in the limiter is available, keeping track
+// of the time spent waiting
+func (s *ServiceCheck) waitForSlot() bool {
+	start := time.Now()
+
+	checkQueuedMetric.Inc()
+	defer checkQueuedMetric.Dec()
+
+	// waiting for other checks is not considered stalling
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case s.limiter <- struct{}{}:
+			checkQueueDelayMetric.Observe(time.Since(start).Seconds())
+
+			return true
+		case <-heartbeat.C:
+			s.markProgress()
+		case <-s.stopped:
+			return false
+		}
+	}
+}
+
+// releaseSlot releases the slot acquired by acquireSlot
+func (s *ServiceCheck) releaseSlot() {
+	checkRunningMetric.Dec()
+
+	if s.limiter != nil {
+		<-s.limiter
+	}
+}
+
+// setupLogger sets up a dedicated logger if the log level is overridden for
+// this service. This happens after the logging itself is set up, so the logger
+// shares its output.
+func (s *ServiceCheck) setupLogger() {
+	if s.logLevel != nil {
+		s.log = newServiceLogger(*s.logLevel)
+	}
+}
+
+// logger returns a log entry for this service, honoring its log level
+func (s *ServiceCheck) logger() *log.Entry {
+	logger := s.log
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+
+	return logger.WithField("service", s.name)
 }
 
 // Name returns the service check's name
@@ -236,48 +564,292 @@ func (s *ServiceCheck) Name() string {
 
 // IsUp returns whether the service is considered up by birdwatcher
 func (s *ServiceCheck) IsUp() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return (s.state == ServiceStateUp)
 }
 
+// Status returns a snapshot of the current state and counters of the service
+func (s *ServiceCheck) Status() ServiceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefixes := make([]string, len(s.prefixes))
+	for i, p := range s.prefixes {
+		prefixes[i] = p.String()
+	}
+
+	state := s.state
+	if state == "" {
+		// service hasn't reached any state yet
+		state = ServiceStateDown
+	}
+
+	return ServiceStatus{
+		Name:         s.name,
+		FunctionName: s.FunctionName,
+		State:        state,
+		Successes:    s.successes,
+		Degraded:     s.degraded,
+		Unknowns:     s.unknowns,
+		Failures:     s.failures,
+		Timeouts:     s.timeouts,
+		Transitions:  s.transitions,
+		LastCheck:    s.lastCheck,
+		LastError:    s.lastError,
+		LastResult:   s.lastResult,
+		Prefixes:     prefixes,
+		Depends:      s.Depends,
+		Type:         s.Type,
+	}
+}
+
+// currentState returns the state of the service
+func (s *ServiceCheck) currentState() ServiceState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state
+}
+
+// forceDown marks the service down regardless of the results of its checks and
+// returns the resulting action, or nil if the service wasn't up
+func (s *ServiceCheck) forceDown() *Action {
+	s.mu.Lock()
+
+	if s.state != ServiceStateUp {
+		s.mu.Unlock()
+
+		return nil
+	}
+
+	s.previousState = s.state
+	s.state = ServiceStateDown
+	s.transitions++
+	s.forcedDown.Store(true)
+	s.mu.Unlock()
+
+	// update state metric
+	serviceStateMetric.WithLabelValues(s.name).Set(0)
+	// update transition metrics
+	serviceTransitionMetric.WithLabelValues(s.name).Inc()
+	serviceLastTransitionMetric.WithLabelValues(s.name).SetToCurrentTime()
+
+	return s.getAction()
+}
+
+// isComposite returns whether the state of the service is derived from other
+// services rather than from running a check command
+func (s *ServiceCheck) isComposite() bool {
+	return s.depends != nil
+}
+
+// dependsOn returns whether the depends expression of the service references
+// given service
+func (s *ServiceCheck) dependsOn(name string) bool {
+	return s.depends != nil && slices.Contains(s.depends.services(nil), name)
+}
+
+// evaluateDependencies evaluates the depends expression of a composite
+// service and returns an Action when this changed its state, or nil otherwise
+func (s *ServiceCheck) evaluateDependencies(isUp func(name string) bool) *Action {
+	state := ServiceStateDown
+	if s.depends.eval(isUp) {
+		state = ServiceStateUp
+	}
+
+	action := s.transitionTo(state)
+	if action != nil {
+		s.logger().WithField("depends", s.Depends).Infof("service transitioning to %s", state)
+	}
+
+	return action
+}
+
+// transitionTo sets the state of the service and returns an Action if this
+// changed its state, or nil otherwise
+func (s *ServiceCheck) transitionTo(state ServiceState) *Action {
+	if s.currentState() == state {
+		return nil
+	}
+
+	s.setState(state)
+
+	// update state metric
+	if state == ServiceStateUp {
+		serviceStateMetric.WithLabelValues(s.name).Set(1)
+	} else {
+		serviceStateMetric.WithLabelValues(s.name).Set(0)
+	}
+
+	// update transition metrics
+	serviceTransitionMetric.WithLabelValues(s.name).Inc()
+	serviceLastTransitionMetric.WithLabelValues(s.name).SetToCurrentTime()
+
+	return s.getAction()
+}
+
+// setState updates the state of the service and counts the transition
+func (s *ServiceCheck) setState(state ServiceState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.previousState = s.state
+	s.state = state
+	s.transitions++
+}
+
+// recordCheck updates the counters of the service with the result of a check
+func (s *ServiceCheck) recordCheck(result CheckResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastCheck = time.Now()
+	s.lastResult = &result
+
+	s.lastError = ""
+	if result.Err != nil {
+		s.lastError = result.Err.Error()
+	}
+
+	switch result.Outcome {
+	case CheckOutcomeSuccess:
+		s.successes++
+	case CheckOutcomeDegraded:
+		s.successes++
+		s.degraded++
+	case CheckOutcomeUnknown:
+		s.unknowns++
+	case CheckOutcomeFailure:
+		s.failures++
+		if errors.Is(result.Err, context.DeadlineExceeded) {
+			s.timeouts++
+		}
+	}
+}
+
 func (s *ServiceCheck) getAction() *Action {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return &Action{
-		Service:  s,
-		State:    s.state,
-		Prefixes: s.prefixes,
+		Service:       s,
+		State:         s.state,
+		PreviousState: s.previousState,
+		Prefixes:      s.prefixes,
 	}
 }
 
-func (s *ServiceCheck) performCheck() error {
-	sLog := log.WithFields(log.Fields{
-		"service": s.name,
+func (s *ServiceCheck) performCheck() CheckResult {
+	sLog := s.logger().WithFields(log.Fields{
 		"command": s.Command,
 	})
 	sLog.Debug("performing check")
 
+	// determine command and arguments to execute
+	commandArgs, err := buildCommand(s.Command, s.Args, s.Shell)
+	if err != nil {
+		return CheckResult{Outcome: CheckOutcomeFailure, ExitCode: -1, Err: err}
+	}
+
 	// create context that automatically times out
 	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
 	defer cancel()
 
-	// split reload command into command/args assuming the first part is the command
-	// and the rest are the arguments
-	commandArgs := strings.Split(s.Command, " ")
-
 	// set up command execution within that context
-	cmd := exec.CommandContext(ctx, commandArgs[0], commandArgs[1:]...)
+	cmd := commandContext(ctx, commandArgs)
+	cmd.Env = s.commandEnv()
+	cmd.Dir = s.WorkDir
+
+	// drop privileges if configured
+	cmd.SysProcAttr.Credential = s.credential
+
+	// only retain the tail of the output of the command
+	stdout := newTailBuffer(checkOutputSize)
+	stderr := newTailBuffer(checkOutputSize)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	beginCheck := time.Now()
+	err = cmd.Run()
+
+	result := CheckResult{
+		Outcome:  CheckOutcomeFailure,
+		ExitCode: -1,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Duration: time.Since(beginCheck),
+	}
 
-	// get exit code of command
-	output, err := cmd.Output()
+	// exit code is only known when the command actually ran to completion
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
 
 	// We want to check the context error to see if the timeout was executed.
-	// The error returned by cmd.Output() will be OS specific based on what
+	// The error returned by cmd.Run() will be OS specific based on what
 	// happens when a process is killed.
 	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-		return ctx.Err()
+		result.Err = ctx.Err()
+
+		return result
 	}
 
 	if err != nil {
-		sLog.WithError(err).WithField("output", output).Debug("check output")
+		sLog.WithError(err).WithFields(result.logFields()).Debug("check output")
+
+		result.Err = err
+
+		// the command did not run at all
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return result
+		}
+	}
+
+	// interpret the exit code of the command
+	result.Outcome = s.ExitCodes.outcome(result.ExitCode)
+
+	return result
+}
+
+// commandEnv returns the environment for the check command: the environment of
+// birdwatcher itself, the configured variables and variables describing the
+// service
+func (s *ServiceCheck) commandEnv() []string {
+	env := os.Environ()
+
+	for k, v := range s.Env {
+		env = append(env, k+"="+v)
+	}
+
+	prefixes := make([]string, len(s.prefixes))
+	for i, p := range s.prefixes {
+		prefixes[i] = p.String()
 	}
 
-	return err
+	state := s.currentState()
+	if state == "" {
+		// service hasn't reached any state yet
+		state = ServiceStateDown
+	}
+
+	return append(env,
+		"BIRDWATCHER_SERVICE="+s.name,
+		"BIRDWATCHER_FUNCTION_NAME="+s.FunctionName,
+		"BIRDWATCHER_PREFIXES="+strings.Join(prefixes, " "),
+		"BIRDWATCHER_STATE="+string(state),
+	)
+}
+
+// logFields returns the details of the check result as log fields
+func (r CheckResult) logFields() log.Fields {
+	return log.Fields{
+		"outcome":   r.Outcome,
+		"exit_code": r.ExitCode,
+		"stdout":    r.Stdout,
+		"stderr":    r.Stderr,
+		"duration":  r.Duration,
+	}
 }
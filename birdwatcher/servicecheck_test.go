@@ -1,27 +1,37 @@
 package birdwatcher
 
 import (
+	"context"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServiceCheckPushChannel(t *testing.T) {
 	t.Parallel()
 
+	// the check fails once the marker exists
+	marker := filepath.Join(t.TempDir(), "fail")
+
 	buf := make(chan *Action)
 	sc := ServiceCheck{
 		disablePrefixCheck: true,
 		name:               "test",
-		Command:            "/usr/bin/true",
+		Command:            "/usr/bin/test",
+		Args:               []string{"!", "-e", marker},
 		Fail:               3,
 		Rise:               2,
-		Interval:           1,
+		Interval:           time.Second,
 		Timeout:            2 * time.Second,
-		prefixes: []net.IPNet{
-			{IP: net.IP{1, 2, 3, 4}, Mask: net.IPMask{255, 255, 255, 0}},
+		prefixes: []Prefix{
+			{IPNet: net.IPNet{IP: net.IP{1, 2, 3, 4}, Mask: net.IPMask{255, 255, 255, 0}}},
 		},
 	}
 
@@ -36,9 +46,372 @@ func TestServiceCheckPushChannel(t *testing.T) {
 	assert.Equal(t, sc.prefixes[0], action.Prefixes[0])
 
 	// all of a sudden, the check gives wrong result
-	sc.Command = "/usr/bin/false"
+	require.NoError(t, os.WriteFile(marker, nil, 0o600))
 
 	// wait for action on channel
 	action = <-buf
 	assert.Equal(t, ServiceStateDown, action.State)
+	assert.Equal(t, ServiceStateUp, action.PreviousState)
+
+	// check metrics were updated
+	assert.Positive(t, testutil.ToFloat64(serviceLastSuccessMetric.WithLabelValues("test")))
+	assert.Positive(t, testutil.ToFloat64(serviceLastTransitionMetric.WithLabelValues("test")))
+	// other tests may have observed check durations of their services as well
+	assert.GreaterOrEqual(t, testutil.CollectAndCount(serviceCheckDuration, "birdwatcher_service_check_duration_seconds"), 1)
+}
+
+func TestServiceCheckStartDelay(t *testing.T) {
+	t.Parallel()
+
+	// the check fails once the marker exists
+	marker := filepath.Join(t.TempDir(), "fail")
+
+	buf := make(chan *Action)
+	sc := ServiceCheck{
+		disablePrefixCheck: true,
+		name:               "test_start_delay",
+		Command:            "/usr/bin/test",
+		Args:               []string{"!", "-e", marker},
+		Fail:               1,
+		Rise:               1,
+		Interval:           50 * time.Millisecond,
+		Timeout:            time.Second,
+		startDelay:         300 * time.Millisecond,
+	}
+
+	start := time.Now()
+
+	go sc.Start(&buf)
+	defer sc.Stop()
+
+	// the first check should not be delayed
+	action := <-buf
+	assert.Equal(t, ServiceStateUp, action.State)
+	assert.Less(t, time.Since(start), sc.startDelay)
+
+	require.NoError(t, os.WriteFile(marker, nil, 0o600))
+	up := time.Now()
+
+	// the next check should be shifted by the start delay
+	action = <-buf
+	assert.Equal(t, ServiceStateDown, action.State)
+	assert.GreaterOrEqual(t, time.Since(up), sc.startDelay)
+}
+
+func TestServiceCheckForceDown(t *testing.T) {
+	t.Parallel()
+
+	buf := make(chan *Action)
+	sc := ServiceCheck{
+		disablePrefixCheck: true,
+		name:               "test_force_down",
+		Command:            "/usr/bin/true",
+		Fail:               1,
+		Rise:               3,
+		Interval:           100 * time.Millisecond,
+		Timeout:            time.Second,
+	}
+
+	go sc.Start(&buf)
+	defer sc.Stop()
+
+	action := <-buf
+	assert.Equal(t, ServiceStateUp, action.State)
+
+	forced := time.Now()
+	require.NotNil(t, sc.forceDown())
+
+	// the service should rise again, rather than go up on the next success
+	action = <-buf
+	assert.Equal(t, ServiceStateUp, action.State)
+	assert.GreaterOrEqual(t, time.Since(forced), 2*sc.Interval)
+}
+
+func TestServiceCheckPerformCheck(t *testing.T) {
+	t.Parallel()
+
+	t.Run("stdout captured", func(t *testing.T) {
+		t.Parallel()
+
+		sc := ServiceCheck{name: "test", Command: "/bin/echo foo", Timeout: time.Second}
+
+		result := sc.performCheck()
+		assert.NoError(t, result.Err)
+		assert.Equal(t, CheckOutcomeSuccess, result.Outcome)
+		assert.Equal(t, 0, result.ExitCode)
+		assert.Equal(t, "foo\n", result.Stdout)
+		assert.Empty(t, result.Stderr)
+		assert.Positive(t, result.Duration)
+	})
+
+	t.Run("stderr and exit code captured", func(t *testing.T) {
+		t.Parallel()
+
+		sc := ServiceCheck{name: "test", Command: "/bin/ls /nonexistent", Timeout: time.Second}
+
+		result := sc.performCheck()
+		assert.Error(t, result.Err)
+		assert.Equal(t, 2, result.ExitCode)
+		assert.Contains(t, result.Stderr, "/nonexistent")
+
+		// result should be retained for the status
+		sc.recordCheck(result)
+		status := sc.Status()
+		assert.Equal(t, uint64(1), status.Failures)
+		if assert.NotNil(t, status.LastResult) {
+			assert.Equal(t, 2, status.LastResult.ExitCode)
+		}
+	})
+
+	t.Run("degraded exit code", func(t *testing.T) {
+		t.Parallel()
+
+		sc := ServiceCheck{
+			name:      "test",
+			Command:   "/usr/bin/false",
+			Timeout:   time.Second,
+			ExitCodes: ExitCodes{Degraded: []int{1}},
+		}
+
+		result := sc.performCheck()
+		assert.Equal(t, CheckOutcomeDegraded, result.Outcome)
+
+		sc.recordCheck(result)
+		status := sc.Status()
+		assert.Equal(t, uint64(1), status.Successes)
+		assert.Equal(t, uint64(1), status.Degraded)
+	})
+
+	t.Run("unknown exit code", func(t *testing.T) {
+		t.Parallel()
+
+		sc := ServiceCheck{
+			name:      "test",
+			Command:   "/usr/bin/false",
+			Timeout:   time.Second,
+			ExitCodes: ExitCodes{Unknown: []int{1}},
+		}
+
+		result := sc.performCheck()
+		assert.Equal(t, CheckOutcomeUnknown, result.Outcome)
+
+		sc.recordCheck(result)
+		status := sc.Status()
+		assert.Zero(t, status.Successes)
+		assert.Zero(t, status.Failures)
+		assert.Equal(t, uint64(1), status.Unknowns)
+	})
+
+	t.Run("command not found", func(t *testing.T) {
+		t.Parallel()
+
+		// even when mapped, not being able to run the command is a failure
+		sc := ServiceCheck{
+			name:      "test",
+			Command:   "/nonexistent",
+			Timeout:   time.Second,
+			ExitCodes: ExitCodes{Unknown: []int{255}},
+		}
+
+		result := sc.performCheck()
+		assert.Error(t, result.Err)
+		assert.Equal(t, CheckOutcomeFailure, result.Outcome)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+
+		sc := ServiceCheck{name: "test", Command: "/bin/sleep 1", Timeout: 10 * time.Millisecond}
+
+		result := sc.performCheck()
+		assert.ErrorIs(t, result.Err, context.DeadlineExceeded)
+		assert.Equal(t, CheckOutcomeFailure, result.Outcome)
+		assert.Equal(t, -1, result.ExitCode)
+	})
+
+	t.Run("timeout with forked child", func(t *testing.T) {
+		t.Parallel()
+
+		sc := ServiceCheck{name: "test", Command: "sleep 3; true", Shell: true, Timeout: 300 * time.Millisecond}
+
+		result := sc.performCheck()
+		assert.ErrorIs(t, result.Err, context.DeadlineExceeded)
+		assert.Equal(t, CheckOutcomeFailure, result.Outcome)
+		assert.Less(t, result.Duration, 2*time.Second)
+	})
+}
+
+func TestServiceCheckPerformCheckCommand(t *testing.T) {
+	t.Parallel()
+
+	t.Run("quoted arguments", func(t *testing.T) {
+		t.Parallel()
+
+		sc := ServiceCheck{name: "test", Command: `/bin/echo "foo  bar" 'baz'`, Timeout: time.Second}
+
+		result := sc.performCheck()
+		assert.NoError(t, result.Err)
+		assert.Equal(t, "foo  bar baz\n", result.Stdout)
+	})
+
+	t.Run("args", func(t *testing.T) {
+		t.Parallel()
+
+		sc := ServiceCheck{name: "test", Command: "/bin/echo", Args: []string{"foo  bar", "baz"}, Timeout: time.Second}
+
+		result := sc.performCheck()
+		assert.NoError(t, result.Err)
+		assert.Equal(t, "foo  bar baz\n", result.Stdout)
+	})
+
+	t.Run("shell", func(t *testing.T) {
+		t.Parallel()
+
+		sc := ServiceCheck{name: "test", Command: "echo foo | tr a-z A-Z; exit 3", Shell: true, Timeout: time.Second}
+
+		result := sc.performCheck()
+		assert.Equal(t, 3, result.ExitCode)
+		assert.Equal(t, "FOO\n", result.Stdout)
+	})
+
+	t.Run("invalid command", func(t *testing.T) {
+		t.Parallel()
+
+		sc := ServiceCheck{name: "test", Command: `/bin/echo "foo`, Timeout: time.Second}
+
+		result := sc.performCheck()
+		assert.Error(t, result.Err)
+		assert.Equal(t, CheckOutcomeFailure, result.Outcome)
+	})
+	t.Run("environment", func(t *testing.T) {
+		t.Parallel()
+
+		sc := ServiceCheck{
+			name:         "test",
+			FunctionName: "match_route",
+			Command:      `echo "$FOO $BIRDWATCHER_SERVICE $BIRDWATCHER_FUNCTION_NAME $BIRDWATCHER_STATE $BIRDWATCHER_PREFIXES"`,
+			Shell:        true,
+			Env:          map[string]string{"FOO": "bar"},
+			Timeout:      time.Second,
+			state:        ServiceStateUp,
+		}
+		sc.prefixes = make([]Prefix, 2)
+		_, prefix, _ := parsePrefix("192.168.0.0/24")
+		sc.prefixes[0] = prefix
+		_, prefix, _ = parsePrefix("fc00::/7")
+		sc.prefixes[1] = prefix
+
+		result := sc.performCheck()
+		assert.NoError(t, result.Err)
+		assert.Equal(t, "bar test match_route up 192.168.0.0/24 fc00::/7\n", result.Stdout)
+	})
+
+	t.Run("initial state", func(t *testing.T) {
+		t.Parallel()
+
+		sc := ServiceCheck{name: "test", Command: `echo "$BIRDWATCHER_STATE"`, Shell: true, Timeout: time.Second}
+
+		result := sc.performCheck()
+		assert.NoError(t, result.Err)
+		assert.Equal(t, "down\n", result.Stdout)
+	})
+
+	t.Run("workdir", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		sc := ServiceCheck{name: "test", Command: "/bin/pwd", WorkDir: dir, Timeout: time.Second}
+
+		result := sc.performCheck()
+		assert.NoError(t, result.Err)
+		assert.Equal(t, dir+"\n", result.Stdout)
+	})
+
+	t.Run("credential", func(t *testing.T) {
+		t.Parallel()
+
+		if os.Geteuid() != 0 {
+			t.Skip("dropping privileges requires root")
+		}
+
+		cred, err := lookupCredential("65534", "65534")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		sc := ServiceCheck{name: "test", Command: `/usr/bin/id -u`, Timeout: time.Second, credential: cred}
+
+		result := sc.performCheck()
+		assert.NoError(t, result.Err)
+		assert.Equal(t, "65534\n", result.Stdout)
+	})
+}
+
+func TestServiceCheckSlots(t *testing.T) {
+	t.Parallel()
+
+	limiter := make(chan struct{}, 1)
+	first := ServiceCheck{name: "first", limiter: limiter, stopped: make(chan any)}
+	second := ServiceCheck{name: "second", limiter: limiter, stopped: make(chan any)}
+
+	assert.True(t, first.acquireSlot())
+
+	// second check has to wait until the first one is done
+	acquired := make(chan bool)
+	go func() {
+		acquired <- second.acquireSlot()
+	}()
+
+	select {
+	case <-acquired:
+		assert.Fail(t, "slot acquired while limiter was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.releaseSlot()
+	assert.True(t, <-acquired)
+
+	// stopping a waiting check aborts waiting
+	go func() {
+		acquired <- first.acquireSlot()
+	}()
+
+	first.stopped <- true
+	assert.False(t, <-acquired)
+
+	second.releaseSlot()
+
+	// without limiter, checks never wait
+	unlimited := ServiceCheck{name: "unlimited"}
+	assert.True(t, unlimited.acquireSlot())
+	unlimited.releaseSlot()
+}
+
+func TestServiceCheckLogger(t *testing.T) {
+	t.Parallel()
+
+	// without log level, the standard logger is used
+	sc := ServiceCheck{name: "test"}
+	sc.setupLogger()
+	assert.Equal(t, log.StandardLogger(), sc.logger().Logger)
+
+	// composite services log with their own log level as well
+	level := log.TraceLevel
+	composite := ServiceCheck{name: "composite", Depends: "test", logLevel: &level}
+	composite.depends, _ = parseDependencies(composite.Depends)
+	composite.setupLogger()
+	assert.Equal(t, log.TraceLevel, composite.logger().Logger.GetLevel())
+	assert.Equal(t, "composite", composite.logger().Data["service"])
+}
+
+func TestServiceCheckInterval(t *testing.T) {
+	t.Parallel()
+
+	sc := ServiceCheck{Interval: time.Second, FastInterval: 100 * time.Millisecond}
+	assert.Equal(t, time.Second, sc.checkInterval(false))
+	assert.Equal(t, 100*time.Millisecond, sc.checkInterval(true))
+
+	// without fast interval, the regular interval is used
+	sc.FastInterval = 0
+	assert.Equal(t, time.Second, sc.checkInterval(true))
 }
@@ -0,0 +1,38 @@
+package birdwatcher
+
+import "sync"
+
+// tailBuffer is an io.Writer that only retains the last bytes written to it,
+// up to a fixed size
+type tailBuffer struct {
+	mu   sync.Mutex
+	size int
+	data []byte
+}
+
+// newTailBuffer returns a tailBuffer retaining at most size bytes
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{size: size}
+}
+
+// Write appends given bytes to the buffer, dropping the oldest bytes if the
+// buffer exceeds its size
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.data = append(t.data, p...)
+	if len(t.data) > t.size {
+		t.data = t.data[len(t.data)-t.size:]
+	}
+
+	return len(p), nil
+}
+
+// String returns the retained bytes as string
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return string(t.data)
+}
@@ -0,0 +1,31 @@
+package birdwatcher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailBuffer(t *testing.T) {
+	t.Parallel()
+
+	buf := newTailBuffer(8)
+	assert.Empty(t, buf.String())
+
+	// write less than the size of the buffer
+	n, err := buf.Write([]byte("foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "foo", buf.String())
+
+	// exceed the size, only the tail should be retained
+	n, err = buf.Write([]byte("barbaz"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, "oobarbaz", buf.String())
+
+	// single write larger than the buffer
+	_, err = buf.Write([]byte("0123456789"))
+	assert.NoError(t, err)
+	assert.Equal(t, "23456789", buf.String())
+}
@@ -0,0 +1,187 @@
+package birdwatcher
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// settings that define a service itself, which can't be shared by defaults or
+// templates
+var ownSettings = []string{"depends", "type", "prefixes", "prefixfile"}
+
+// settings only used by services running a check command, which composite
+// and passive services don't inherit
+var commandSettings = []string{"command", "args", "shell"}
+
+// serviceSettings are service settings from a table in the configuration,
+// along with the keys defined in that table
+type serviceSettings struct {
+	settings *ServiceCheck
+	defined  map[string]bool
+}
+
+// applyTemplates sets the settings given services don't define themselves, as
+// decoded using given metadata, from the template they inherit from and from
+// the defaults. This happens before the built-in defaults are set, so those
+// only apply to settings that are defined in neither.
+func applyTemplates(md toml.MetaData, conf *Config, services map[string]*ServiceCheck) error {
+	templates := make(map[string]serviceSettings, len(conf.Templates))
+
+	for name, t := range conf.Templates {
+		templates[name] = serviceSettings{settings: t, defined: definedKeys(conf.meta, "templates", name)}
+		if err := validateShared(templates[name], "template "+name); err != nil {
+			return err
+		}
+	}
+
+	var defaults *serviceSettings
+
+	if conf.Defaults != nil {
+		defaults = &serviceSettings{settings: conf.Defaults, defined: definedKeys(conf.meta, "defaults")}
+		if err := validateShared(*defaults, "defaults"); err != nil {
+			return err
+		}
+	}
+
+	// walk the services in a fixed order for consistent error messages
+	for _, name := range slices.Sorted(maps.Keys(services)) {
+		s := services[name]
+
+		// sources in order of precedence
+		var sources []serviceSettings
+
+		if s.Inherit != "" {
+			t, found := templates[s.Inherit]
+			if !found {
+				return fmt.Errorf("service %s inherits from unknown template %s", name, s.Inherit)
+			}
+
+			sources = append(sources, t)
+		}
+
+		if defaults != nil {
+			sources = append(sources, *defaults)
+		}
+
+		inheritSettings(s, definedKeys(md, "services", name), sources)
+	}
+
+	return nil
+}
+
+// validateShared checks whether given defaults or template only set settings
+// that can be shared by services
+func validateShared(shared serviceSettings, what string) error {
+	if shared.defined["inherit"] {
+		return fmt.Errorf("%s can not inherit from a template", what)
+	}
+
+	for _, key := range ownSettings {
+		if shared.defined[key] {
+			return fmt.Errorf("%s can not set %s", what, key)
+		}
+	}
+
+	return nil
+}
+
+// inheritSettings copies each setting not defined for given service from the
+// first of given sources defining it
+func inheritSettings(s *ServiceCheck, defined map[string]bool, sources []serviceSettings) {
+	dst := reflect.ValueOf(s).Elem()
+
+	// composite and passive services don't run a check command, so they would
+	// be refused when inheriting one
+	runsCommand := s.Depends == "" && s.Type != ServiceTypePassive
+
+	for i := range dst.NumField() {
+		field := dst.Type().Field(i)
+		if !field.IsExported() || field.Name == "Inherit" {
+			continue
+		}
+
+		key := strings.ToLower(field.Name)
+		if defined[key] || (!runsCommand && slices.Contains(commandSettings, key)) {
+			continue
+		}
+
+		for _, source := range sources {
+			if source.defined[key] {
+				dst.Field(i).Set(cloneValue(reflect.ValueOf(source.settings).Elem().Field(i)))
+
+				break
+			}
+		}
+	}
+}
+
+// definedKeys returns the keys defined in the table at given path, in lower
+// case, since keys are matched to fields case insensitively
+func definedKeys(md toml.MetaData, path ...string) map[string]bool {
+	keys := map[string]bool{}
+
+	for _, key := range md.Keys() {
+		if name, ok := tableKey(key, path); ok {
+			keys[name] = true
+		}
+	}
+
+	return keys
+}
+
+// tableKey returns the name of given key in lower case, if it is defined
+// directly in the table at given path
+func tableKey(key toml.Key, path []string) (string, bool) {
+	if len(key) != len(path)+1 || !strings.EqualFold(key[0], path[0]) || !slices.Equal(key[1:len(path)], path[1:]) {
+		return "", false
+	}
+
+	return strings.ToLower(key[len(path)]), true
+}
+
+// cloneValue returns a copy of given value that doesn't share slices or maps
+// with it, so services inheriting the same settings can't affect each other
+func cloneValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+
+		clone := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := range v.Len() {
+			clone.Index(i).Set(cloneValue(v.Index(i)))
+		}
+
+		return clone
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+
+		clone := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			clone.SetMapIndex(k, cloneValue(v.MapIndex(k)))
+		}
+
+		return clone
+	case reflect.Struct:
+		clone := reflect.New(v.Type()).Elem()
+		clone.Set(v)
+
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				clone.Field(i).Set(cloneValue(v.Field(i)))
+			}
+		}
+
+		return clone
+	default:
+		return v
+	}
+}
@@ -0,0 +1,125 @@
+package birdwatcher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyTemplates(t *testing.T) {
+	t.Parallel()
+
+	t.Run("defaults and templates", func(t *testing.T) {
+		t.Parallel()
+
+		testConf := Config{}
+
+		err := ReadConfig(&testConf, "testdata/config/templates")
+		require.NoError(t, err)
+
+		foo := testConf.Services["foo"]
+		assert.Equal(t, 5*time.Second, foo.Interval)
+		assert.Equal(t, 3*time.Second, foo.Timeout)
+		assert.Equal(t, "match_web", foo.FunctionName)
+		assert.Equal(t, 3, foo.Fail)
+		assert.Equal(t, 5, foo.Rise)
+		assert.Equal(t, map[string]string{"SITE": "ams"}, foo.Env)
+
+		bar := testConf.Services["bar"]
+		assert.Equal(t, 5*time.Second, bar.Interval)
+		assert.Equal(t, time.Second, bar.Timeout)
+		assert.Equal(t, "match_default", bar.FunctionName)
+		assert.Equal(t, defaultServiceFail, bar.Fail)
+		assert.Equal(t, defaultServiceRise, bar.Rise)
+		assert.Nil(t, bar.Env)
+
+		// services inheriting the same template shouldn't share its settings
+		baz := testConf.Services["baz"]
+		assert.Equal(t, 2, baz.Rise)
+		assert.Equal(t, foo.Env, baz.Env)
+		assert.NotEqual(t, reflect.ValueOf(foo.Env).Pointer(), reflect.ValueOf(baz.Env).Pointer())
+	})
+
+	t.Run("mixed case keys", func(t *testing.T) {
+		t.Parallel()
+
+		testConf := Config{}
+
+		err := ReadConfig(&testConf, "testdata/config/templates_mixedcase")
+		require.NoError(t, err)
+
+		// keys are case insensitive, so the setting of the service wins
+		assert.Equal(t, "match_foo", testConf.Services["foo"].FunctionName)
+		assert.Equal(t, 3, testConf.Services["foo"].Fail)
+	})
+
+	t.Run("mixed case intervals", func(t *testing.T) {
+		t.Parallel()
+
+		testConf := Config{}
+
+		err := ReadConfig(&testConf, "testdata/config/intervals_mixedcase")
+		require.NoError(t, err)
+
+		// intervals in whole seconds are converted regardless of the case of
+		// their keys
+		assert.Equal(t, 5*time.Second, testConf.Services["foo"].Interval)
+		assert.Equal(t, 2*time.Second, testConf.Services["foo"].FastInterval)
+		assert.Equal(t, 10*time.Second, testConf.Services["bar"].Interval)
+		assert.Equal(t, 2*time.Second, testConf.Services["bar"].FastInterval)
+	})
+
+	t.Run("command of composite and passive services", func(t *testing.T) {
+		t.Parallel()
+
+		testConf := Config{}
+
+		err := ReadConfig(&testConf, "testdata/config/templates_command")
+		require.NoError(t, err)
+
+		foo := testConf.Services["foo"]
+		assert.Equal(t, "/usr/bin/true", foo.Command)
+		assert.True(t, foo.Shell)
+
+		// composite and passive services don't inherit the command, but do
+		// inherit other settings
+		for _, name := range []string{"bar", "baz"} {
+			assert.Empty(t, testConf.Services[name].Command, name)
+			assert.False(t, testConf.Services[name].Shell, name)
+			assert.Equal(t, 3*time.Second, testConf.Services[name].Timeout, name)
+		}
+	})
+
+	t.Run("shared prefixes", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/templates_prefixes")
+		if assert.Error(t, err) {
+			assert.Equal(t, "defaults can not set prefixes", err.Error())
+		}
+	})
+
+	t.Run("unknown template", func(t *testing.T) {
+		t.Parallel()
+
+		err := ReadConfig(&Config{}, "testdata/config/templates_unknown")
+		if assert.Error(t, err) {
+			assert.Equal(t, "service foo inherits from unknown template web", err.Error())
+		}
+	})
+}
+
+func TestCloneValue(t *testing.T) {
+	t.Parallel()
+
+	codes := ExitCodes{Degraded: []int{1}, Unknown: []int{3}}
+	clone := cloneValue(reflect.ValueOf(codes)).Interface().(ExitCodes)
+
+	assert.Equal(t, codes, clone)
+
+	clone.Degraded[0] = 2
+	assert.Equal(t, []int{1}, codes.Degraded)
+}
@@ -0,0 +1,10 @@
+! DO NOT EDIT MANUALLY
+{{- range .Lists }}
+{{- $list := . }}
+{{- range .Entries }}
+{{ $list.Family }} prefix-list {{ $list.Name }} seq {{ .Seq }} {{ .Rule }}
+{{- end }}
+{{- range .Stale }}
+no {{ $list.Family }} prefix-list {{ $list.Name }} seq {{ . }}
+{{- end }}
+{{- end }}
@@ -3,8 +3,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -41,6 +43,9 @@ func main() {
 		debugFlag   = flag.Bool("debug", false, "increase loglevel to debug")
 		useSystemd  = flag.Bool("systemd", false, "optimize behavior for running under systemd")
 		versionFlag = flag.Bool("version", false, "show version and exit")
+		logFormat   = flag.String("log-format", "", "log format, text or json (overrides config file)")
+		logOutput   = flag.String("log-output", "", "log output, stdout, file, syslog or journald (overrides config file)")
+		logFile     = flag.String("log-file", "", "path to log file, implies -log-output file (overrides config file)")
 	)
 
 	flag.Parse()
@@ -82,6 +87,10 @@ func main() {
 		fmt.Printf("Configuration file %s OK\n", *configFile)
 
 		if *debugFlag {
+			for _, file := range config.Files() {
+				fmt.Printf("Loaded configuration file %s\n", file)
+			}
+
 			configJSON, err := json.MarshalIndent(config, "", "  ")
 			if err != nil {
 				log.Fatal(err.Error())
@@ -93,20 +102,77 @@ func main() {
 		return
 	}
 
-	// enable prometheus
-	// Expose /metrics HTTP endpoint using the created custom registry.
+	// command line flags take precedence over the config file
+	if *logFormat != "" {
+		config.Log.Format = *logFormat
+	}
+
+	if *logFile != "" {
+		config.Log.Output = birdwatcher.LogOutputFile
+		config.Log.File = *logFile
+	}
+
+	if *logOutput != "" {
+		config.Log.Output = *logOutput
+	}
+
+	if err := birdwatcher.ConfigureLogging(config.Log, *useSystemd); err != nil {
+		log.WithError(err).Fatal("could not configure logging")
+	}
+
+	// create health checker
+	hc := birdwatcher.NewHealthCheck(config)
+	ready := make(chan bool)
+
+	// enable prometheus and the status API
+	// endpoints sharing a port are served by the same HTTP server
+	muxes := make(map[int]*http.ServeMux)
+	getMux := func(port int) *http.ServeMux {
+		if _, found := muxes[port]; !found {
+			muxes[port] = http.NewServeMux()
+		}
+
+		return muxes[port]
+	}
+
 	if config.Prometheus.Enabled {
+		log.WithFields(log.Fields{
+			"port": config.Prometheus.Port,
+			"path": config.Prometheus.Path,
+		}).Info("starting prometheus exporter")
+
+		getMux(config.Prometheus.Port).Handle(config.Prometheus.Path, promhttp.Handler())
+	}
+
+	if config.API.Enabled {
+		log.WithFields(log.Fields{
+			"port": config.API.Port,
+			"path": birdwatcher.APIPathPrefix,
+		}).Info("starting status API")
+
+		apiHandler := birdwatcher.NewAPIHandler(hc)
+		getMux(config.API.Port).Handle(birdwatcher.APIPathPrefix, apiHandler)
+
+		// also serve the status API on a unix socket if configured
+		if config.API.Socket != "" {
+			log.WithField("socket", config.API.Socket).Info("starting status API on unix socket")
+
+			go func() {
+				if err := startSocketServer(config.API.Socket, apiHandler); err != nil {
+					log.WithError(err).Fatal("could not start status API on unix socket")
+				}
+			}()
+		}
+	}
+
+	for port, mux := range muxes {
 		go func() {
-			if err := startPrometheus(config.Prometheus); err != nil {
-				log.WithError(err).Fatal("could not start prometheus exporter")
+			if err := startHTTPServer(port, mux); err != nil {
+				log.WithError(err).Fatal("could not start HTTP server")
 			}
 		}()
 	}
 
-	// start health checker
-	hc := birdwatcher.NewHealthCheck(config)
-	ready := make(chan bool)
-
 	// create status update channel for systemd
 	// give it a little buffer so the chances of it blocking the health check
 	// is low
@@ -129,8 +195,32 @@ func main() {
 	if *useSystemd {
 		log.Debug("notifying systemd birdwatcher is ready")
 		sdnotify(daemon.SdNotifyReady)
+
+		// ping the systemd watchdog if configured for this unit
+		watchdogInterval, err := daemon.SdWatchdogEnabled(false)
+		if err != nil {
+			log.WithError(err).Error("could not determine systemd watchdog interval")
+		} else if watchdogInterval > 0 {
+			go watchdog(hc, watchdogInterval)
+		}
 	}
 
+	// reopen log file on SIGUSR1, so it can be rotated
+	reopenCh := make(chan os.Signal, 1)
+	signal.Notify(reopenCh, syscall.SIGUSR1)
+
+	go func() {
+		for range reopenCh {
+			if err := birdwatcher.ReopenLogFile(); err != nil {
+				log.WithError(err).Error("could not reopen log file")
+
+				continue
+			}
+
+			log.Info("reopened log file")
+		}
+	}()
+
 	// wait until interrupted
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, os.Interrupt)
@@ -156,17 +246,29 @@ func sdnotify(msg string) {
 	}
 }
 
-func startPrometheus(c birdwatcher.PrometheusConfig) error {
-	log.WithFields(log.Fields{
-		"port": c.Port,
-		"path": c.Path,
-	}).Info("starting prometheus exporter")
+// watchdog notifies the systemd watchdog as long as the health checker is making
+// progress, so systemd restarts birdwatcher when it got stuck
+func watchdog(hc *birdwatcher.HealthCheck, interval time.Duration) {
+	log.WithField("interval", interval).Info("enabling systemd watchdog")
+
+	// systemd recommends notifying at half the watchdog interval
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := hc.CheckProgress(); err != nil {
+			log.WithError(err).Error("not notifying systemd watchdog")
+
+			continue
+		}
 
-	mux := http.NewServeMux()
-	mux.Handle(c.Path, promhttp.Handler())
+		sdnotify(daemon.SdNotifyWatchdog)
+	}
+}
 
+func startHTTPServer(port int, mux *http.ServeMux) error {
 	httpServer := &http.Server{
-		Addr:         fmt.Sprintf("0.0.0.0:%d", c.Port),
+		Addr:         fmt.Sprintf("0.0.0.0:%d", port),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		Handler:      mux,
@@ -174,3 +276,23 @@ func startPrometheus(c birdwatcher.PrometheusConfig) error {
 
 	return httpServer.ListenAndServe()
 }
+
+func startSocketServer(path string, handler http.Handler) error {
+	// remove a stale socket of a previous run
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	httpServer := &http.Server{
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		Handler:      handler,
+	}
+
+	return httpServer.Serve(listener)
+}